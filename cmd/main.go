@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
@@ -16,8 +20,10 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	// Your real local imports:
+	"github.com/sharif-go-lab/go-download-manager/internal/config"
 	"github.com/sharif-go-lab/go-download-manager/internal/queue"
 	"github.com/sharif-go-lab/go-download-manager/internal/task"
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
 )
 
 // -----------------------------------------------------------------------------
@@ -67,17 +73,36 @@ func formatSpeed(bps uint64) string {
 	return fmt.Sprintf("%.1f GB/s", float64(bps)/(1024.0*1024.0*1024.0))
 }
 
-func (m *Model) updateSpeeds() {
-	// Build a list of all tasks from all queues:
-	for _, rq := range m.realQueues {
-		for _, t := range rq.Tasks() {
-			current := t.Downloaded()
-			prev := m.prevDownloaded[t]
-			diff := current - prev // bytes downloaded in last second
+func formatETA(d time.Duration, ok bool) string {
+	if !ok {
+		return "--"
+	}
+	d = d.Round(time.Second)
+	if d >= 100*time.Hour {
+		return ">99h"
+	} else if d >= time.Hour {
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	} else if d >= time.Minute {
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
 
-			m.speeds[t] = diff // bytes/sec
-			m.prevDownloaded[t] = current
+// subscribe forwards the events of rq to the model's event channel until
+// the queue is unsubscribed.
+func (m *Model) subscribe(rq *queue.Queue) {
+	ch := rq.Subscribe(64)
+	m.subscriptions[rq] = ch
+	go func() {
+		for e := range ch {
+			m.events <- e
 		}
+	}()
+}
+
+func waitForEvent(events <-chan task.Event) tea.Cmd {
+	return func() tea.Msg {
+		return eventMsg{<-events}
 	}
 }
 
@@ -95,6 +120,9 @@ type KeyMap struct {
 	Delete      key.Binding
 	PauseResume key.Binding
 	Retry       key.Binding
+	Restart     key.Binding
+	Details     key.Binding
+	SpeedLimit  key.Binding
 	EditQueue   key.Binding
 	DeleteQueue key.Binding
 	AddQueue    key.Binding
@@ -141,6 +169,18 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("r"),
 			key.WithHelp("r", "retry"),
 		),
+		Restart: key.NewBinding(
+			key.WithKeys("R"),
+			key.WithHelp("R", "restart from scratch"),
+		),
+		Details: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "details"),
+		),
+		SpeedLimit: key.NewBinding(
+			key.WithKeys("l"),
+			key.WithHelp("l", "speed limit"),
+		),
 		EditQueue: key.NewBinding(
 			key.WithKeys("e"),
 			key.WithHelp("e", "edit queue"),
@@ -193,17 +233,19 @@ func (k KeyMap) FullHelp() [][]key.Binding {
 		{k.Tab1, k.Tab2, k.Tab3},
 		{k.Up, k.Down, k.Left, k.Right, k.Tab},
 		{k.Enter, k.Escape},
-		{k.Delete, k.PauseResume, k.Retry},
+		{k.Delete, k.PauseResume, k.Retry, k.Restart, k.Details, k.SpeedLimit},
 		{k.EditQueue, k.DeleteQueue, k.AddQueue},
 		{k.Help, k.Quit},
 	}
 }
 
 // -----------------------------------------------------------------------------
-// tickMsg for periodic refresh
+// eventMsg carries a task event from the queues
 // -----------------------------------------------------------------------------
 
-type tickMsg time.Time
+type eventMsg struct {
+	event task.Event
+}
 
 // -----------------------------------------------------------------------------
 // Model
@@ -220,18 +262,28 @@ type Model struct {
 	// We'll keep a slice of real queues:
 	realQueues    []*queue.Queue
 	selectedQueue int // which queue is selected in the Queues List
-	mu            sync.Mutex
+	stateDir      string
+	queuesPath    string // where the queue settings are saved
+	cfg           *config.Config
+	netrc         *utils.Netrc
+	globalLimiter *utils.Limiter // shared by all queues
 
 	// Tab 1: Add Download
 	urlInput         textinput.Model
 	folderInput      textinput.Model
 	filenameInput    textinput.Model
-	addFormFocus     int  // 0=URL,1=Queue selection,2=Folder,3=Filename
+	checksumInput    textinput.Model
+	headersInput     textinput.Model
+	authInput        textinput.Model
+	cookiesInput     textinput.Model
+	startAtInput     textinput.Model
+	addFormFocus     int  // 0=URL,1=Queue selection,2=Folder,3=Checksum,4=Headers,5=Auth,6=Cookies,7=Start at
 	selectedQForAdd  int  // which queue is chosen for the new download
 	creatingDownload bool // not strictly needed, but a simple state marker
 
 	// Tab 2: Downloads
-	selectedDownload int // index into the combined tasks of all queues
+	selectedDownload int  // index into the combined tasks of all queues
+	showDetails      bool // show the selected task's details and error history
 
 	// Tab 3: "Queues UI" (lightweight info for each queue)
 	queues           []QueueUI
@@ -242,11 +294,20 @@ type Model struct {
 	queueFolderInput textinput.Model
 	queueMaxDlInput  textinput.Model
 	queueSpeedInput  textinput.Model
+	queueSchedInput  textinput.Model
 	queueTimeInput   textinput.Model
-	// For speed tracking:
-	prevDownloaded map[*task.Task]uint64 // how many bytes were downloaded at last tick
-	speeds         map[*task.Task]uint64 // current speed in bytes/s for each task
-	errorMsg       string
+	queueHeaderInput textinput.Model
+	queueAuthInput   textinput.Model
+	queueCookieInput textinput.Model
+	errorMsg         string
+
+	// Speed limit prompt: a task's with L on Downloads, the global one on Queues
+	editLimitMode bool
+	limitTask     *task.Task // nil for the global limit
+	limitInput    textinput.Model
+
+	events        chan task.Event
+	subscriptions map[*queue.Queue]<-chan task.Event
 }
 
 // QueueUI is a minimal struct that parallels the real queues in `m.realQueues`.
@@ -262,38 +323,59 @@ type QueueUI struct {
 // init Model
 // -----------------------------------------------------------------------------
 
-func initialModel() Model {
-	// Create some example queues for demonstration
-	q1 := queue.NewQueue("Default", "~/Downloads", 3, 2, 3, 0, nil)
-	//q1.SetDirectory("~/Downloads")
-	go q1.Run()
+func initialModel(cfg *config.Config) Model {
+	var errorMsg string
 
-	//q2 := queue.NewQueue("Videos", "~/Documents", 2, 3, 2, 0, nil)
-	//q2.SetDirectory("VideosFolder")
-	//go q2.Run()
+	// One budget for all queues, split between the busy ones
+	globalLimiter := utils.NewLimiter(uint64(max(cfg.SpeedLimitKbps, 0)) * 1024)
+	if schedule, err := utils.ParseBandwidthSchedule(cfg.BandwidthSchedule); err != nil {
+		slog.Error(fmt.Sprintf("invalid bandwidth schedule: %v", err))
+		errorMsg = fmt.Sprintf("bandwidth schedule: %v", err)
+	} else {
+		globalLimiter.SetSchedule(schedule)
+	}
 
-	// If you have any more, add them here:
-	// q3 := ...
-	// go q3.Run()
+	// Credentials by host, the way curl and wget use them
+	netrcPath := cfg.NetrcFile
+	if netrcPath == "" {
+		netrcPath = utils.DefaultNetrcPath()
+	}
+	netrc, err := utils.LoadNetrc(netrcPath)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to read %s: %v", netrcPath, err))
+		errorMsg = fmt.Sprintf("netrc: %v", err)
+	}
 
-	realQueues := []*queue.Queue{q1}
+	// Queues and tasks from previous sessions are saved here
+	stateDir, err := task.DefaultStateDir()
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to locate state directory: %v", err))
+	}
+	queuesPath, err := queue.DefaultStatePath()
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to locate queue file: %v", err))
+	}
+	realQueues, err := restoreQueues(queuesPath)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to restore queues: %v", err))
+		errorMsg = fmt.Sprintf("queues: %v", err)
+	}
+	if len(realQueues) == 0 {
+		realQueues = []*queue.Queue{queue.NewQueue("Default", "~/Downloads", 3, 2, 3, 0, nil)}
+	}
+	for _, rq := range realQueues {
+		if err := setupQueue(rq, cfg, globalLimiter, netrc, stateDir); err != nil {
+			slog.Error(fmt.Sprintf("failed to apply http settings: %v", err))
+			errorMsg = fmt.Sprintf("http settings: %v", err)
+		}
+	}
+	restoreTasks(realQueues, stateDir)
 
 	// Build a parallel UI slice:
-	queuesUI := []QueueUI{
-		{
-			Name:         q1.Name,
-			Folder:       q1.Directory,
-			MaxDownloads: int(q1.MaxDownloads),
-			SpeedLimit:   q1.SpeedLimit,
-			TimeWindow:   "Always",
-		},
-		//{
-		//	Name:         q2.Name,
-		//	Folder:       q2.Directory,
-		//	MaxDownloads: int(q2.MaxDownloads),
-		//	SpeedLimit:   q2.SpeedLimit,
-		//	TimeWindow:   "Always",
-		//},
+	queuesUI := make([]QueueUI, 0, len(realQueues))
+	for _, rq := range realQueues {
+		go rq.Run()
+		queuesUI = append(queuesUI, newQueueUI(rq))
 	}
 
 	// Prepare text inputs
@@ -307,6 +389,22 @@ func initialModel() Model {
 	filenameInput := textinput.New()
 	filenameInput.Placeholder = "(Optional) Custom filename"
 
+	checksumInput := textinput.New()
+	checksumInput.Placeholder = "(Optional) sha256:9f86d08... or auto"
+
+	headersInput := textinput.New()
+	headersInput.Placeholder = "(Optional) X-Api-Key: abc; Accept: */*"
+
+	authInput := textinput.New()
+	authInput.Placeholder = "(Optional) user:password or Bearer <token>"
+	authInput.EchoMode = textinput.EchoPassword
+
+	cookiesInput := textinput.New()
+	cookiesInput.Placeholder = "(Optional) path to cookies.txt"
+
+	startAtInput := textinput.New()
+	startAtInput.Placeholder = "(Optional) 23:30 or 2006-01-02 23:30"
+
 	// For editing queue settings
 	queueNameInput := textinput.New()
 	queueNameInput.Placeholder = "Queue Name"
@@ -320,26 +418,54 @@ func initialModel() Model {
 	queueSpeedInput := textinput.New()
 	queueSpeedInput.Placeholder = "Speed Limit"
 
+	queueSchedInput := textinput.New()
+	queueSchedInput.Placeholder = "09:00-18:00 200"
+
 	queueTimeInput := textinput.New()
-	queueTimeInput.Placeholder = "Time Window"
+	queueTimeInput.Placeholder = "Always"
+
+	queueHeaderInput := textinput.New()
+	queueHeaderInput.Placeholder = "Headers"
+
+	queueAuthInput := textinput.New()
+	queueAuthInput.Placeholder = "user:password or Bearer <token>"
+	queueAuthInput.EchoMode = textinput.EchoPassword
+
+	queueCookieInput := textinput.New()
+	queueCookieInput.Placeholder = "Cookies file"
+
+	limitInput := textinput.New()
+	limitInput.Placeholder = "KB/s, 0 for unlimited"
 
 	keys := DefaultKeyMap()
 	helpModel := help.New()
 	helpModel.ShowAll = false
 
-	return Model{
+	m := Model{
 		keys:       keys,
 		help:       helpModel,
 		showHelp:   false,
 		activeTab:  0,
 		realQueues: realQueues,
 		queues:     queuesUI,
-		mu:         sync.Mutex{},
+		stateDir:   stateDir,
+		queuesPath: queuesPath,
+		cfg:        cfg,
+		netrc:      netrc,
+		errorMsg:   errorMsg,
+
+		globalLimiter: globalLimiter,
+		limitInput:    limitInput,
 
 		// Tab 1 (Add)
 		urlInput:      urlInput,
 		folderInput:   folderInput,
 		filenameInput: filenameInput,
+		checksumInput: checksumInput,
+		headersInput:  headersInput,
+		authInput:     authInput,
+		cookiesInput:  cookiesInput,
+		startAtInput:  startAtInput,
 		addFormFocus:  0,
 		// selectedQForAdd = 0 means queue #0 is chosen by default
 
@@ -355,22 +481,154 @@ func initialModel() Model {
 		queueFolderInput: queueFolderInput,
 		queueMaxDlInput:  queueMaxDlInput,
 		queueSpeedInput:  queueSpeedInput,
+		queueSchedInput:  queueSchedInput,
 		queueTimeInput:   queueTimeInput,
-		prevDownloaded: make(map[*task.Task]uint64),
-		speeds:         make(map[*task.Task]uint64),
+		queueHeaderInput: queueHeaderInput,
+		queueAuthInput:   queueAuthInput,
+		queueCookieInput: queueCookieInput,
+		events:           make(chan task.Event, 64),
+		subscriptions:    make(map[*queue.Queue]<-chan task.Event),
 	}
+	for _, rq := range realQueues {
+		m.subscribe(rq)
+	}
+	return m
+}
+
+// restoreQueues rebuilds the queues saved by the last session. A queue whose
+// settings partly fail to apply still comes back, and the error says why.
+func restoreQueues(path string) ([]*queue.Queue, error) {
+	if path == "" {
+		return nil, nil
+	}
+	states, err := queue.LoadStates(path)
+	if err != nil {
+		return nil, err
+	}
+	var queues []*queue.Queue
+	var errs []error
+	for _, s := range states {
+		rq, err := queue.Restore(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+		}
+		queues = append(queues, rq)
+	}
+	return queues, errors.Join(errs...)
+}
+
+// setupQueue attaches a queue to what all queues share: the global limit,
+// the HTTP settings, .netrc and the state directory.
+func setupQueue(rq *queue.Queue, cfg *config.Config, globalLimiter *utils.Limiter, netrc *utils.Netrc, stateDir string) error {
+	rq.SetStateDir(stateDir)
+	rq.SetGlobalLimiter(globalLimiter)
+	rq.SetNetrc(netrc)
+	return rq.SetHTTPOptions(cfg.HTTP)
+}
+
+// newQueueUI describes rq for the Queues tab.
+func newQueueUI(rq *queue.Queue) QueueUI {
+	return QueueUI{
+		Name:         rq.Name,
+		Folder:       rq.Directory,
+		MaxDownloads: int(rq.MaxDownloads),
+		SpeedLimit:   rq.SpeedLimit,
+		TimeWindow:   rq.TimeWindow(),
+	}
+}
+
+// saveQueues writes the queue settings for the next launch.
+func (m Model) saveQueues() {
+	if m.queuesPath == "" {
+		return
+	}
+	states := make([]queue.State, 0, len(m.realQueues))
+	for _, rq := range m.realQueues {
+		states = append(states, rq.State())
+	}
+	if err := queue.SaveStates(m.queuesPath, states); err != nil {
+		slog.Error(fmt.Sprintf("failed to save queues: %v", err))
+	}
+}
+
+// restoreTasks re-attaches saved tasks to the queue they were added to,
+// falling back to the first queue if it no longer exists.
+func restoreTasks(queues []*queue.Queue, stateDir string) {
+	if stateDir == "" || len(queues) == 0 {
+		return
+	}
+	states, err := task.LoadStates(stateDir)
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to load saved tasks: %v", err))
+		return
+	}
+	for _, s := range states {
+		target := queues[0]
+		for _, rq := range queues {
+			if rq.Name == s.Queue {
+				target = rq
+				break
+			}
+		}
+		target.RestoreTask(s)
+	}
+}
+
+// loadConfig reads config.yaml from the user's config directory, falling back
+// to the defaults if there is none.
+func loadConfig() (*config.Config, error) {
+	path, err := config.DefaultConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	return config.LoadConfig(path)
+}
+
+// openLogFile opens the log in the user's cache directory. It names files
+// and hosts the user downloads, so only the user can read it.
+func openLogFile() (*os.File, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "go-download-manager")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, "download-manager.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// Logs written by older versions were readable by everyone
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
 }
 
 func main() {
-	// Example logger at error-level
+	// Log to a file: anything written to stdout would be drawn over the UI
 	level := new(slog.LevelVar)
-	level.Set(slog.LevelError)
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	level.Set(slog.LevelInfo)
+	logFile, err := openLogFile()
+	if err != nil {
+		fmt.Println("Error opening log file:", err)
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	logger := slog.New(slog.NewTextHandler(logFile, &slog.HandlerOptions{
 		Level: level,
 	}))
 	slog.SetDefault(logger)
 
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		os.Exit(1)
+	}
+
+	p := tea.NewProgram(initialModel(cfg), tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Println("Error running program:", err)
 		os.Exit(1)
@@ -382,9 +640,7 @@ func main() {
 // -----------------------------------------------------------------------------
 
 func (m Model) Init() tea.Cmd {
-	return tea.Every(time.Second, func(t time.Time) tea.Msg {
-		return tickMsg(t)
-	})
+	return waitForEvent(m.events)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -392,12 +648,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
-	case tickMsg:
-		// refresh the UI
-		m.updateSpeeds()
-		return m, tea.Every(time.Second, func(t time.Time) tea.Msg {
-			return tickMsg(t)
-		})
+	case eventMsg:
+		// the task state is read when rendering
+		return m, waitForEvent(m.events)
 
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -442,6 +695,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmds = append(cmds, cmd)
 			return m, tea.Batch(cmds...)
 		}
+		if m.editLimitMode {
+			m, cmd = m.updateEditLimit(msg)
+			return m, cmd
+		}
 
 		// Otherwise handle per-tab logic
 		switch m.activeTab {
@@ -473,7 +730,7 @@ func (m Model) updateTabAdd(msg tea.Msg) (Model, tea.Cmd) {
 			m.addFormFocus = max(0, m.addFormFocus-1)
 
 		case key.Matches(msg, m.keys.Down):
-			m.addFormFocus = min(2, m.addFormFocus+1)
+			m.addFormFocus = min(7, m.addFormFocus+1)
 
 		case key.Matches(msg, m.keys.Left):
 			// If we are on the queue selection row, pressing left changes the queue
@@ -494,17 +751,23 @@ func (m Model) updateTabAdd(msg tea.Msg) (Model, tea.Cmd) {
 
 		case key.Matches(msg, m.keys.Enter):
 			// If not yet at last field, move forward
-			if m.addFormFocus < 2 {
+			if m.addFormFocus < 7 {
 				m.addFormFocus++
 			} else {
 				// On last field => attempt to add
+				opts, optsErr := parseRequestOptions(m.headersInput.Value(), m.authInput.Value(), m.cookiesInput.Value())
+				startAt, startErr := parseStartAt(m.startAtInput.Value(), time.Now())
 				if m.urlInput.Value() == "" {
 					m.errorMsg = "URL is required"
+				} else if optsErr != nil {
+					m.errorMsg = optsErr.Error()
+				} else if startErr != nil {
+					m.errorMsg = startErr.Error()
 				} else {
 					// Add to whichever queue is selected
 					if m.selectedQForAdd < len(m.realQueues) {
 						chosenQ := m.realQueues[m.selectedQForAdd]
-						err := chosenQ.AddTask(m.urlInput.Value(), m.folderInput.Value())
+						err := chosenQ.AddTask(m.urlInput.Value(), m.folderInput.Value(), m.checksumInput.Value(), opts, startAt)
 						if err != nil {
 						m.errorMsg = err.Error()
 					}else {
@@ -512,6 +775,11 @@ func (m Model) updateTabAdd(msg tea.Msg) (Model, tea.Cmd) {
 							m.urlInput.Reset()
 							m.folderInput.Reset()
 							m.filenameInput.Reset()
+							m.checksumInput.Reset()
+							m.headersInput.Reset()
+							m.authInput.Reset()
+							m.cookiesInput.Reset()
+							m.startAtInput.Reset()
 							m.urlInput.Focus()
 							m.addFormFocus = 0
 							m.selectedQForAdd = 0
@@ -530,35 +798,31 @@ func (m Model) updateTabAdd(msg tea.Msg) (Model, tea.Cmd) {
 			m.urlInput.Reset()
 			m.folderInput.Reset()
 			m.filenameInput.Reset()
+			m.checksumInput.Reset()
+			m.headersInput.Reset()
+			m.authInput.Reset()
+			m.cookiesInput.Reset()
+			m.startAtInput.Reset()
 			m.urlInput.Focus()
 			m.addFormFocus = 0
 			m.selectedQForAdd = 0
 		}
 	}
 
-	// Update whichever textinput is in focus
-	switch m.addFormFocus {
-	case 0:
-		m.urlInput.Focus()
-		m.folderInput.Blur()
-		m.filenameInput.Blur()
-		m.urlInput, cmd = m.urlInput.Update(msg)
-	case 1:
-		// The "queue selection" row is not a textinput,
-		// so we only handle left/right keys above.
-		m.urlInput.Blur()
-		m.folderInput.Blur()
-		m.filenameInput.Blur()
-	case 2:
-		m.urlInput.Blur()
-		m.folderInput.Focus()
-		m.filenameInput.Blur()
-		m.folderInput, cmd = m.folderInput.Update(msg)
-		//case 3:
-		//	m.urlInput.Blur()
-		//	m.folderInput.Blur()
-		//	m.filenameInput.Focus()
-		//	m.filenameInput, cmd = m.filenameInput.Update(msg)
+	// Update whichever textinput is in focus. The "queue selection" row
+	// (1) is not a textinput, so we only handle left/right keys above.
+	m.filenameInput.Blur()
+	inputs := []*textinput.Model{&m.urlInput, nil, &m.folderInput, &m.checksumInput, &m.headersInput, &m.authInput, &m.cookiesInput, &m.startAtInput}
+	for i, input := range inputs {
+		if input == nil {
+			continue
+		}
+		if i == m.addFormFocus {
+			input.Focus()
+			*input, cmd = input.Update(msg)
+		} else {
+			input.Blur()
+		}
 	}
 	return m, cmd
 }
@@ -587,6 +851,9 @@ func (m Model) updateTabDownloads(msg tea.Msg) Model {
 				allTasks[m.selectedDownload].task.Cancel()
 			}
 
+		case key.Matches(msg, m.keys.Details):
+			m.showDetails = !m.showDetails
+
 		case key.Matches(msg, m.keys.PauseResume):
 			if len(allTasks) > 0 && m.selectedDownload < len(allTasks) {
 				t := allTasks[m.selectedDownload]
@@ -597,21 +864,33 @@ func (m Model) updateTabDownloads(msg tea.Msg) Model {
 					t.task.Resume()
 				case task.Failed:
 					// treat as a “retry”
-					//t.task.Cancel()
-					t.queue.AddTask(t.task.Url(),t.task.DirectoryPath)
+					if err := t.task.Retry(); err != nil {
+						m.errorMsg = err.Error()
+					}
+				}
+			}
 
+		case key.Matches(msg, m.keys.Retry):
+			if len(allTasks) > 0 && m.selectedDownload < len(allTasks) {
+				if err := allTasks[m.selectedDownload].task.Retry(); err != nil {
+					m.errorMsg = err.Error()
+				}
+			}
 
+		case key.Matches(msg, m.keys.Restart):
+			if len(allTasks) > 0 && m.selectedDownload < len(allTasks) {
+				if err := allTasks[m.selectedDownload].task.RetryFromScratch(); err != nil {
+					m.errorMsg = err.Error()
 				}
 			}
 
-		case key.Matches(msg, m.keys.Retry):
+		case key.Matches(msg, m.keys.SpeedLimit):
 			if len(allTasks) > 0 && m.selectedDownload < len(allTasks) {
-				t := allTasks[m.selectedDownload]
-				//if t.task.Status() == task.Failed {
-				t.task.Cancel()
-				//t.task.Resume()
-				t.queue.AddTask(t.task.Url(),t.task.DirectoryPath)
-				//}
+				t := allTasks[m.selectedDownload].task
+				m.editLimitMode = true
+				m.limitTask = t
+				m.limitInput.SetValue(fmt.Sprintf("%d", t.SpeedLimit()))
+				m.limitInput.Focus()
 			}
 		}
 	}
@@ -633,9 +912,11 @@ func (m Model) updateTabQueues(msg tea.Msg) Model {
 
 		case key.Matches(msg, m.keys.DeleteQueue):
 			if m.selectedQueue < len(m.realQueues) {
-				// Stop & remove from realQueues
+				// Delete its downloads & remove from realQueues
 				toRemove := m.realQueues[m.selectedQueue]
-				toRemove.Stop()
+				toRemove.Delete()
+				toRemove.Unsubscribe(m.subscriptions[toRemove])
+				delete(m.subscriptions, toRemove)
 
 				m.realQueues = append(m.realQueues[:m.selectedQueue],
 					m.realQueues[m.selectedQueue+1:]...)
@@ -647,6 +928,7 @@ func (m Model) updateTabQueues(msg tea.Msg) Model {
 				if m.selectedQueue >= len(m.queues) {
 					m.selectedQueue = max(0, len(m.queues)-1)
 				}
+				m.saveQueues()
 			}
 
 		case key.Matches(msg, m.keys.EditQueue):
@@ -660,26 +942,37 @@ func (m Model) updateTabQueues(msg tea.Msg) Model {
 				m.queueFolderInput.SetValue(qUI.Folder)
 				m.queueMaxDlInput.SetValue(fmt.Sprintf("%d", qUI.MaxDownloads))
 				m.queueSpeedInput.SetValue(fmt.Sprintf("%d", qUI.SpeedLimit))
+				m.queueSchedInput.SetValue(m.realQueues[m.selectedQueue].BandwidthSchedule().String())
 				m.queueTimeInput.SetValue(qUI.TimeWindow)
+
+				opts := m.realQueues[m.selectedQueue].RequestOptions()
+				m.queueHeaderInput.SetValue(formatHeaders(opts.Header))
+				m.queueAuthInput.SetValue(formatAuth(opts))
+				m.queueCookieInput.SetValue(opts.CookieFile)
 			}
 
+		case key.Matches(msg, m.keys.SpeedLimit):
+			m.editLimitMode = true
+			m.limitTask = nil
+			m.limitInput.SetValue(fmt.Sprintf("%d", m.globalLimiter.Rate()/1024))
+			m.limitInput.Focus()
+
 		case key.Matches(msg, m.keys.AddQueue):
 			// Create a brand new real queue
 			newRealQ := queue.NewQueue("NewQueue", "Downloads", 2, 2, 3, 0, nil)
 			//newRealQ.SetDirectory("~/Downloads")
+			if err := setupQueue(newRealQ, m.cfg, m.globalLimiter, m.netrc, m.stateDir); err != nil {
+				m.errorMsg = fmt.Sprintf("http settings: %v", err)
+			}
+			m.subscribe(newRealQ)
 			go newRealQ.Run()
 
 			m.realQueues = append(m.realQueues, newRealQ)
 
 			// Also add to UI
-			m.queues = append(m.queues, QueueUI{
-				Name:         newRealQ.Name,
-				Folder:       newRealQ.Directory,
-				MaxDownloads: int(newRealQ.MaxDownloads),
-				SpeedLimit:   newRealQ.SpeedLimit,
-				TimeWindow:   "Always",
-			})
+			m.queues = append(m.queues, newQueueUI(newRealQ))
 			m.selectedQueue = len(m.queues) - 1
+			m.saveQueues()
 		}
 	}
 	return m
@@ -697,10 +990,10 @@ func (m Model) updateEditQueue(msg tea.Msg) (Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Up):
 			m.queueEditFocus = max(0, m.queueEditFocus-1)
 		case key.Matches(msg, m.keys.Down):
-			m.queueEditFocus = min(4, m.queueEditFocus+1)
+			m.queueEditFocus = min(8, m.queueEditFocus+1)
 
 		case key.Matches(msg, m.keys.Enter):
-			if m.queueEditFocus < 4 {
+			if m.queueEditFocus < 8 {
 				m.queueEditFocus++
 			} else {
 				// Save changes
@@ -737,6 +1030,14 @@ func (m Model) updateEditQueue(msg tea.Msg) (Model, tea.Cmd) {
 						qUI.SpeedLimit = uint64(speed)
 					}
 
+					// bandwidth by time of day
+					schedule, err := utils.ParseBandwidthSchedule(m.queueSchedInput.Value())
+					if err != nil {
+						m.errorMsg = "Invalid bandwidth schedule: " + err.Error()
+					} else {
+						rQ.SetBandwidthSchedule(schedule)
+					}
+
 					// time window
 					timeWindowStr := m.queueTimeInput.Value()
 					// Attempt to parse & store in the real queue
@@ -744,13 +1045,21 @@ func (m Model) updateEditQueue(msg tea.Msg) (Model, tea.Cmd) {
 					if err3 != nil {
 						m.errorMsg = "Invalid time window: " + err3.Error()
 					} else {
-						qUI.TimeWindow = timeWindowStr
+						qUI.TimeWindow = rQ.TimeWindow()
+					}
+
+					// headers, credentials and cookies for every task
+					opts, err := parseRequestOptions(m.queueHeaderInput.Value(), m.queueAuthInput.Value(), m.queueCookieInput.Value())
+					if err == nil {
+						err = rQ.SetRequestOptions(opts)
+					}
+					if err != nil {
+						m.errorMsg = err.Error()
 					}
-					// For demonstration only
-					qUI.TimeWindow = m.queueTimeInput.Value()
 
 					// Save back to the UI slice
 					m.queues[m.editQueueIndex] = qUI
+					m.saveQueues()
 				}
 
 				// Exit edit mode
@@ -767,46 +1076,57 @@ func (m Model) updateEditQueue(msg tea.Msg) (Model, tea.Cmd) {
 	}
 
 	// Update whichever text input is in focus
-	switch m.queueEditFocus {
-	case 0:
-		m.queueNameInput.Focus()
-		m.queueFolderInput.Blur()
-		m.queueMaxDlInput.Blur()
-		m.queueSpeedInput.Blur()
-		m.queueTimeInput.Blur()
-		m.queueNameInput, cmd = m.queueNameInput.Update(msg)
-	case 1:
-		m.queueNameInput.Blur()
-		m.queueFolderInput.Focus()
-		m.queueMaxDlInput.Blur()
-		m.queueSpeedInput.Blur()
-		m.queueTimeInput.Blur()
-		m.queueFolderInput, cmd = m.queueFolderInput.Update(msg)
-	case 2:
-		m.queueNameInput.Blur()
-		m.queueFolderInput.Blur()
-		m.queueMaxDlInput.Focus()
-		m.queueSpeedInput.Blur()
-		m.queueTimeInput.Blur()
-		m.queueMaxDlInput, cmd = m.queueMaxDlInput.Update(msg)
-	case 3:
-		m.queueNameInput.Blur()
-		m.queueFolderInput.Blur()
-		m.queueMaxDlInput.Blur()
-		m.queueSpeedInput.Focus()
-		m.queueTimeInput.Blur()
-		m.queueSpeedInput, cmd = m.queueSpeedInput.Update(msg)
-	case 4:
-		m.queueNameInput.Blur()
-		m.queueFolderInput.Blur()
-		m.queueMaxDlInput.Blur()
-		m.queueSpeedInput.Blur()
-		m.queueTimeInput.Focus()
-		m.queueTimeInput, cmd = m.queueTimeInput.Update(msg)
+	inputs := []*textinput.Model{
+		&m.queueNameInput, &m.queueFolderInput, &m.queueMaxDlInput, &m.queueSpeedInput, &m.queueSchedInput,
+		&m.queueTimeInput, &m.queueHeaderInput, &m.queueAuthInput, &m.queueCookieInput,
+	}
+	for i, input := range inputs {
+		if i == m.queueEditFocus {
+			input.Focus()
+			*input, cmd = input.Update(msg)
+		} else {
+			input.Blur()
+		}
 	}
 	return m, cmd
 }
 
+// -----------------------------------------------------------------------------
+// Update logic: Editing a speed limit
+// -----------------------------------------------------------------------------
+
+func (m Model) updateEditLimit(msg tea.Msg) (Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Enter):
+			limit, err := strconv.ParseUint(strings.TrimSpace(m.limitInput.Value()), 10, 64)
+			if err != nil {
+				m.errorMsg = "Invalid speed limit: enter KB/s, 0 for unlimited"
+				return m, nil
+			}
+			if m.limitTask != nil {
+				m.limitTask.SetSpeedLimit(limit)
+			} else {
+				m.globalLimiter.SetRate(limit * 1024)
+			}
+			m.editLimitMode = false
+			m.limitTask = nil
+			m.limitInput.Blur()
+			return m, nil
+
+		case key.Matches(msg, m.keys.Escape):
+			m.editLimitMode = false
+			m.limitTask = nil
+			m.limitInput.Blur()
+			return m, nil
+		}
+	}
+	m.limitInput, cmd = m.limitInput.Update(msg)
+	return m, cmd
+}
+
 // -----------------------------------------------------------------------------
 // Views
 // -----------------------------------------------------------------------------
@@ -838,6 +1158,13 @@ func (m Model) View() string {
 		case 2:
 			content = m.viewTabQueues()
 		}
+		if m.editLimitMode {
+			label := "Global speed limit (KB/s): "
+			if m.limitTask != nil {
+				label = "Download speed limit (KB/s): "
+			}
+			content += "\n> " + label + m.limitInput.View() + "\n"
+		}
 	}
 
 	windowContent := windowStyle.Width(m.width - 10).Render(content)
@@ -900,6 +1227,32 @@ func (m Model) viewTabAdd() string {
 	}
 	b.WriteString(folderLabel + m.folderInput.View() + "\n\n")
 
+	// 3) Checksum
+	checksumLabel := "Checksum (optional): "
+	if m.addFormFocus == 3 {
+		checksumLabel = "> " + checksumLabel
+	} else {
+		checksumLabel = "  " + checksumLabel
+	}
+	b.WriteString(checksumLabel + m.checksumInput.View() + "\n\n")
+
+	// 4-7) Headers, credentials, cookies and start time
+	for i, field := range []struct {
+		label string
+		input textinput.Model
+	}{
+		{"Headers (optional): ", m.headersInput},
+		{"Auth (optional): ", m.authInput},
+		{"Cookies (optional): ", m.cookiesInput},
+		{"Start At (optional): ", m.startAtInput},
+	} {
+		label := "  " + field.label
+		if m.addFormFocus == 4+i {
+			label = "> " + field.label
+		}
+		b.WriteString(label + field.input.View() + "\n\n")
+	}
+
 	// 3) Filename
 	//fileLabel := "Filename (optional): "
 	//if m.addFormFocus == 3 {
@@ -925,17 +1278,18 @@ func (m Model) viewTabDownloads() string {
 
 	allTasks := m.getAllDownloads() // queuedTask objects
 
-	// We have “Queue” + “URL” + “Status” + “Progress” + “Speed” + “Downloaded”
+	// We have “Queue” + “URL” + “Status” + “Progress” + “Speed” + “ETA” + “Downloaded”
 	b.WriteString(fmt.Sprintf(
-		"%-10s %-36s %-12s %-15s %-10s %s\n",
+		"%-10s %-36s %-12s %-15s %-10s %-7s %s\n",
 		"Queue",    // 10 chars wide
 		"URL",      // 36 chars wide
 		"Status",   // 12 chars
 		"Progress", // 15 chars
 		"Speed",    // 10 chars
+		"ETA",      // 7 chars
 		"Downloaded",
 	))
-	b.WriteString(strings.Repeat("─", 100) + "\n")
+	b.WriteString(strings.Repeat("─", 108) + "\n")
 
 	for i, item := range allTasks {
 		prefix := "  "
@@ -945,7 +1299,7 @@ func (m Model) viewTabDownloads() string {
 		t := item.task
 
 		queueName := truncateString(item.queue.Name, 10)
-		urlStr    := truncateString(t.Url(), 36)
+		urlStr    := truncateString(redactURL(t.Url()), 36)
 		statusStr := statusToString(t.Status())
 
 		total      := t.TotalSize()
@@ -955,30 +1309,105 @@ func (m Model) viewTabDownloads() string {
 			progress = float64(downloaded) / float64(total)
 		}
 
-		// speed is from your m.speeds map:
-		speedBps := m.speeds[t]
-		speedStr := formatSpeed(speedBps) // "KB/s" etc.
+		speedStr := formatSpeed(t.Speed()) // "KB/s" etc.
+		etaStr := formatETA(t.ETA())
 
-		line := fmt.Sprintf("%s%-10s %-36s %-12s %-15s %-10s %s",
+		// Streamed downloads of unknown size have no percentage to show
+		progressBar := renderProgressBar(progress, 15)
+		sizeStr := fmt.Sprintf("%d/%d bytes", downloaded, total)
+		if total < 0 {
+			sizeStr = fmt.Sprintf("%d bytes", downloaded)
+			if t.Status() == task.InProgress {
+				progressBar = renderIndeterminateBar(15)
+			}
+		}
+
+		line := fmt.Sprintf("%s%-10s %-36s %-12s %-15s %-10s %-7s %s",
 			prefix,
 			queueName,                  // queue column
 			urlStr,                     // url column
 			statusStr,                  // status
-			renderProgressBar(progress, 15),
+			progressBar,
 			speedStr,                   // speed column
-			fmt.Sprintf("%d/%d bytes", downloaded, total),
+			etaStr,                     // remaining time
+			sizeStr,
 		)
 		if i == m.selectedDownload {
 			line = lipgloss.NewStyle().Foreground(highlightColor).Render(line)
 		}
 
 		b.WriteString(line + "\n")
+		if err := t.LastError(); err != nil && (t.Status() == task.Failed || t.Status() == task.VerificationFailed) {
+			b.WriteString(fmt.Sprintf("    ↳ %v\n", err))
+		} else if notice := t.Notice(); notice != "" {
+			b.WriteString(fmt.Sprintf("    ↳ %s\n", notice))
+		} else if startAt := t.StartAt(); t.Status() == task.Pending && startAt.After(time.Now()) {
+			b.WriteString(fmt.Sprintf("    ↳ starts at %s\n", startAt.Format("2006-01-02 15:04")))
+		}
 	}
 
 	if len(allTasks) == 0 {
 		b.WriteString("\nNo tasks. Press F1 to add.\n")
+	} else if m.showDetails && m.selectedDownload < len(allTasks) {
+		b.WriteString(m.viewTaskDetails(allTasks[m.selectedDownload].task))
+	}
+	b.WriteString("\nD=Cancel, P=Pause/Resume, R=Retry failed, Shift+R=Restart, I=Details, L=Speed limit\n")
+	return b.String()
+}
+
+// viewTaskDetails shows everything known about t, including why it failed.
+func (m Model) viewTaskDetails(t *task.Task) string {
+	var b strings.Builder
+	b.WriteString("\n" + titleStyle.Render(" Details ") + "\n\n")
+
+	s := t.State()
+	b.WriteString(fmt.Sprintf("URL:      %s\n", redactURL(s.URL)))
+	b.WriteString(fmt.Sprintf("File:     %s\n", s.FilePath))
+	b.WriteString(fmt.Sprintf("Status:   %s\n", statusToString(s.Status)))
+	if s.FileSize >= 0 {
+		b.WriteString(fmt.Sprintf("Size:     %d bytes\n", s.FileSize))
+	} else {
+		b.WriteString("Size:     unknown\n")
+	}
+	if s.Checksum != "" {
+		b.WriteString(fmt.Sprintf("Checksum: %s\n", s.Checksum))
+	}
+	b.WriteString(fmt.Sprintf("Limit:    %s\n", m.formatLimits(t)))
+	if s.StartAt != nil {
+		b.WriteString(fmt.Sprintf("Start at: %s\n", s.StartAt.Format("2006-01-02 15:04:05")))
+	}
+	if r := s.Request; r != nil {
+		// Never show the secrets themselves
+		if len(r.Header) > 0 {
+			names := make([]string, 0, len(r.Header))
+			for name := range r.Header {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			b.WriteString(fmt.Sprintf("Headers:  %s\n", strings.Join(names, ", ")))
+		}
+		if r.Username != "" {
+			b.WriteString(fmt.Sprintf("Auth:     Basic, as %s\n", r.Username))
+		} else if r.Token != "" {
+			b.WriteString("Auth:     Bearer token\n")
+		}
+		if r.CookieFile != "" {
+			b.WriteString(fmt.Sprintf("Cookies:  %s\n", r.CookieFile))
+		}
+	}
+	if err := t.LastError(); err != nil {
+		b.WriteString(fmt.Sprintf("Reason:   %v\n", err))
+	}
+
+	errs := t.Errors()
+	if len(errs) == 0 {
+		b.WriteString("\nNo errors.\n")
+		return b.String()
+	}
+	b.WriteString("\nErrors:\n")
+	for _, e := range errs {
+		b.WriteString(fmt.Sprintf("  %s  %-8s %v\n", e.Time.Format("2006-01-02 15:04:05"), e.Kind, e.Err))
 	}
-	b.WriteString("\nD=Cancel, P=Pause/Resume, R=Retry failed\n")
 	return b.String()
 }
 
@@ -989,6 +1418,11 @@ func (m Model) viewTabDownloads() string {
 func (m Model) viewTabQueues() string {
 	var b strings.Builder
 	b.WriteString(titleStyle.Render(" Queues ") + "\n\n")
+	b.WriteString(fmt.Sprintf("Global speed limit: %s\n", formatLimit(m.globalLimiter.Rate()/1024)))
+	if schedule := m.globalLimiter.Schedule(); schedule != nil {
+		b.WriteString(fmt.Sprintf("Global schedule:    %s (now %s)\n", schedule, formatLimit(m.globalLimiter.CurrentRate()/1024)))
+	}
+	b.WriteString("\n")
 
 	b.WriteString(fmt.Sprintf("%-15s %-20s %-12s %-10s %-12s\n",
 		"Name", "Folder", "MaxDls", "Speed", "TimeWindow"))
@@ -999,12 +1433,17 @@ func (m Model) viewTabQueues() string {
 		if i == m.selectedQueue {
 			prefix = "> "
 		}
-		line := fmt.Sprintf("%s%-15s %-20s %-12d %-10d %-12s",
+		// A scheduled queue shows the limit in force now
+		speed := fmt.Sprintf("%d", q.SpeedLimit)
+		if i < len(m.realQueues) && m.realQueues[i].BandwidthSchedule() != nil {
+			speed = fmt.Sprintf("%d*", m.realQueues[i].CurrentSpeedLimit())
+		}
+		line := fmt.Sprintf("%s%-15s %-20s %-12d %-10s %-12s",
 			prefix,
 			truncateString(q.Name, 15),
 			truncateString(q.Folder, 20),
 			q.MaxDownloads,
-			q.SpeedLimit,
+			speed,
 			q.TimeWindow,
 		)
 		if i == m.selectedQueue {
@@ -1016,7 +1455,8 @@ func (m Model) viewTabQueues() string {
 	if len(m.queues) == 0 {
 		b.WriteString("\nNo queues. Press N to add.\n")
 	} else {
-		b.WriteString("\nUp/Down=Select queue, E=Edit, D=Delete, N=Add\n")
+		b.WriteString("\nSpeed in KB/s, 0 for unlimited; * set by the bandwidth schedule\n")
+		b.WriteString("Up/Down=Select queue, E=Edit, D=Delete, N=Add, L=Global speed limit\n")
 	}
 	return b.String()
 }
@@ -1061,17 +1501,43 @@ func (m Model) viewEditQueueForm() string {
 	}
 	b.WriteString(label + " " + m.queueSpeedInput.View() + "\n\n")
 
-	// Here’s the updated “Time Window” label, clarifying the format
-	label = "Time Window (HH:MM:SS-HH:MM:SS):"
+	label = "Bandwidth Schedule:"
 	if m.queueEditFocus == 4 {
 		label = "> " + label
 	} else {
 		label = "  " + label
 	}
+	b.WriteString(label + " " + m.queueSchedInput.View() + "\n\n")
+
+	// Here’s the updated “Time Window” label, clarifying the format
+	label = "Time Window:"
+	if m.queueEditFocus == 5 {
+		label = "> " + label
+	} else {
+		label = "  " + label
+	}
 	b.WriteString(label + " " + m.queueTimeInput.View() + "\n\n")
 
+	for i, field := range []struct {
+		label string
+		input textinput.Model
+	}{
+		{"Headers:", m.queueHeaderInput},
+		{"Auth:", m.queueAuthInput},
+		{"Cookies File:", m.queueCookieInput},
+	} {
+		label = "  " + field.label
+		if m.queueEditFocus == 6+i {
+			label = "> " + field.label
+		}
+		b.WriteString(label + " " + field.input.View() + "\n\n")
+	}
+
 	// Some quick instructions
-	b.WriteString("Example: 08:00:00-17:00:00 for an 8am-5pm window\n")
+	b.WriteString("Example: 08:00:00-17:00:00 for an 8am-5pm window, or Mon-Fri 22:00-06:00, Sat-Sun all day, TZ=Europe/Berlin\n")
+	b.WriteString("A cron expression such as 30 22 * * 1-5 starts the queue at those times instead\n")
+	b.WriteString("Schedule example: 09:00-18:00 200, 18:00-20:00 unlimited; the speed limit applies outside it\n")
+	b.WriteString("Headers, auth and cookies apply to every download in the queue.\n")
 	b.WriteString("Up/Down to navigate, Enter to save on last field, Esc=Cancel.\n")
 	return b.String()
 }
@@ -1080,6 +1546,104 @@ func (m Model) viewEditQueueForm() string {
 // Helpers
 // -----------------------------------------------------------------------------
 
+// parseStartAt reads when a download should start: a date and time, or a
+// time of day meaning its next occurrence. Empty means right away.
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if c, err := time.Parse(layout, s); err == nil {
+			t := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), c.Second(), 0, time.Local)
+			if !t.After(now) {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("start time must be HH:MM or YYYY-MM-DD HH:MM")
+}
+
+// parseRequestOptions reads the headers, auth and cookies fields of a form.
+// Auth is either user:password or "Bearer <token>".
+func parseRequestOptions(headers, auth, cookies string) (task.RequestOptions, error) {
+	var opts task.RequestOptions
+	header, err := task.ParseHeaders(headers)
+	if err != nil {
+		return opts, err
+	}
+	if len(header) > 0 {
+		opts.Header = header
+	}
+
+	auth = strings.TrimSpace(auth)
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		opts.Token = strings.TrimSpace(token)
+	} else if auth != "" {
+		user, password, ok := strings.Cut(auth, ":")
+		if !ok || user == "" {
+			return opts, errors.New("auth must be user:password or Bearer <token>")
+		}
+		opts.Username, opts.Password = user, password
+	}
+	opts.CookieFile = strings.TrimSpace(cookies)
+	return opts, nil
+}
+
+// formatLimit renders a limit in KB/s.
+func formatLimit(kbps uint64) string {
+	if kbps == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d KB/s", kbps)
+}
+
+// formatLimits shows the task's own limit and those above it.
+func (m Model) formatLimits(t *task.Task) string {
+	queueLimit := "unlimited"
+	for _, item := range m.getAllDownloads() {
+		if item.task == t {
+			queueLimit = formatLimit(item.queue.CurrentSpeedLimit())
+		}
+	}
+	return fmt.Sprintf("%s (queue %s, global %s)", formatLimit(t.SpeedLimit()), queueLimit, formatLimit(m.globalLimiter.CurrentRate()/1024))
+}
+
+func formatHeaders(header http.Header) string {
+	var parts []string
+	for name, values := range header {
+		for _, v := range values {
+			parts = append(parts, name+": "+v)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "; ")
+}
+
+func formatAuth(opts task.RequestOptions) string {
+	if opts.Username != "" {
+		return opts.Username + ":" + opts.Password
+	} else if opts.Token != "" {
+		return "Bearer " + opts.Token
+	}
+	return ""
+}
+
+// redactURL hides a password embedded in the URL.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
+
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
@@ -1101,6 +1665,8 @@ func statusToString(s task.DownloadStatus) string {
 		return "Canceled"
 	case task.Failed:
 		return "Failed"
+	case task.VerificationFailed:
+		return "Bad Checksum"
 	default:
 		return "Unknown"
 	}
@@ -1124,6 +1690,18 @@ func renderProgressBar(progress float64, width int) string {
 	return sb.String()
 }
 
+// renderIndeterminateBar draws a marker bouncing across the bar, moving one
+// cell per second.
+func renderIndeterminateBar(width int) string {
+	const marker = "<=>"
+	span := width - len(marker)
+	pos := int(time.Now().Unix() % int64(2*span))
+	if pos > span {
+		pos = 2*span - pos
+	}
+	return "[" + strings.Repeat(" ", pos) + marker + strings.Repeat(" ", span-pos) + "]"
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
 	"gopkg.in/yaml.v3"
 )
 
@@ -12,8 +14,24 @@ import (
 type Config struct {
 	DownloadDirectory     string `yaml:"download_directory"`
 	MaxConcurrentDownloads int    `yaml:"max_concurrent_downloads"`
-	SpeedLimitKbps        int    `yaml:"speed_limit_kbps"`
+	SpeedLimitKbps        int    `yaml:"speed_limit_kbps"` // shared by all queues
+	// BandwidthSchedule overrides SpeedLimitKbps by time of day, e.g.
+	// "09:00-18:00 200".
+	BandwidthSchedule string `yaml:"bandwidth_schedule"`
 	LogLevel              string `yaml:"log_level"`
+
+	HTTP utils.HTTPOptions `yaml:"http"`
+	// NetrcFile holds credentials applied by host; empty uses ~/.netrc.
+	NetrcFile string `yaml:"netrc_file"`
+}
+
+// DefaultConfigPath returns where the configuration file is looked up.
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-download-manager", "config.yaml"), nil
 }
 
 // LoadConfig reads configuration from a file and environment variables
@@ -23,6 +41,7 @@ func LoadConfig(configPath string) (*Config, error) {
 		MaxConcurrentDownloads: 3,
 		SpeedLimitKbps:        0,
 		LogLevel:              "info",
+		HTTP:                  utils.DefaultHTTPOptions(),
 	}
 
 	// Read from config file if it exists
@@ -47,6 +66,9 @@ func LoadConfig(configPath string) (*Config, error) {
 	if val := os.Getenv("SPEED_LIMIT_KBPS"); val != "" {
 		fmt.Sscanf(val, "%d", &config.SpeedLimitKbps)
 	}
+	if val := os.Getenv("BANDWIDTH_SCHEDULE"); val != "" {
+		config.BandwidthSchedule = val
+	}
 	if val := os.Getenv("LOG_LEVEL"); val != "" {
 		config.LogLevel = val
 	}
@@ -2,33 +2,51 @@ package queue
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"github.com/sharif-go-lab/go-download-manager/internal/task"
 	"github.com/sharif-go-lab/go-download-manager/internal/utils"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Queue runs its tasks a few at a time. Set Threads, Retries, Preallocate
+// and PauseOnLowSpace before Run; change the other settings through their
+// setters, which may be called while it goes.
 type Queue struct {
+	mutex          sync.Mutex // guards tasks, schedule, cron and the settings the setters change
 	tasks          []*task.Task
 	Name           string
 	Directory      string
 	MaxDownloads   uint8
 	Threads        uint8
 	Retries        uint8
-	SpeedLimit     uint64
-	limiter        <-chan time.Time
-	activeInterval *utils.TimeInterval
+	SpeedLimit     uint64 // KB/s, 0 for unlimited
+	limiter        *utils.Limiter // shared by the queue's tasks
+	schedule       *utils.Schedule // when tasks may run, nil for always
+	cron           *utils.Cron     // when the queue starts, instead of windows
+	wake           chan struct{}
+	stateDir       string
+	events         *task.Bus
+	client         *http.Client // shared by the queue's tasks
+	request        task.RequestOptions
+	jar            http.CookieJar
+	netrc          *utils.Netrc
+
+	// Preallocate reserves disk space for each file before downloading it;
+	// PauseOnLowSpace pauses tasks that run out of space instead of failing.
+	Preallocate     bool
+	PauseOnLowSpace bool
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
 }
 
-func NewQueue(name string, directory string, maxDownloads, threads, retries uint8, speedLimit uint64, activeInterval *utils.TimeInterval) *Queue {
+func NewQueue(name string, directory string, maxDownloads, threads, retries uint8, speedLimit uint64, schedule *utils.Schedule) *Queue {
 	info, err := os.Stat(directory)
 	if err != nil || !info.IsDir() { // configurable
 		dirname, err := os.UserHomeDir()
@@ -48,6 +66,11 @@ func NewQueue(name string, directory string, maxDownloads, threads, retries uint
 		threads = 1 // configurable
 	}
 	ctx, cancelFunc := context.WithCancel(context.Background())
+	client, err := utils.NewHTTPClient(utils.DefaultHTTPOptions())
+	if err != nil {
+		slog.Error(fmt.Sprintf("failed to create http client: %v", err))
+		client = http.DefaultClient
+	}
 
 	return &Queue{
 		tasks:          make([]*task.Task, 0),
@@ -57,21 +80,30 @@ func NewQueue(name string, directory string, maxDownloads, threads, retries uint
 		Threads:        threads,
 		Retries:        retries,
 		SpeedLimit:     speedLimit,
-		limiter:        utils.CreateLimiter(speedLimit),
-		activeInterval: activeInterval,
+		Preallocate:    true,
+		limiter:        utils.NewLimiter(speedLimit * 1024),
+		schedule:       schedule,
+		wake:           make(chan struct{}, 1),
+		events:         task.NewBus(),
+		client:         client,
 		ctx:            ctx,
 		cancelFunc:     cancelFunc,
 	}
 }
 
-func (queue *Queue) AddTask(url string, directory string) error {
+// AddTask queues a download of url. The checksum, in algo:hex form, is
+// optional and verified once the download completes; "auto" looks for a
+// checksum file published next to the download instead. The request options
+// add to those of the queue. A non-zero startAt holds the task back until
+// then.
+func (queue *Queue) AddTask(url string, directory string, checksum string, opts task.RequestOptions, startAt time.Time) error {
 	var dir string
-	fmt.Println(directory)
 	if directory == "" {
+		queue.mutex.Lock()
 		dir = queue.Directory
+		queue.mutex.Unlock()
 
 	} else {
-		fmt.Println("sdt")
 		info, err := os.Stat(directory)
 		if err != nil || !info.IsDir() {
 			dirname, err := os.UserHomeDir()
@@ -92,70 +124,257 @@ func (queue *Queue) AddTask(url string, directory string) error {
 		}
 	}
 	t := task.NewTask(url, dir, queue.Threads, queue.Retries, queue.limiter)
+	if checksum == "auto" {
+		t.SetAutoChecksum(true)
+	} else if err := t.SetChecksum(checksum); err != nil {
+		return err
+	}
+	if err := t.SetRequestOptions(opts); err != nil {
+		return err
+	}
+	queue.configure(t)
+	t.SetStartAt(startAt)
+	queue.mutex.Lock()
 	queue.tasks = append(queue.tasks, t)
+	queue.mutex.Unlock()
+	queue.notify()
 	return nil
 
 }
 
+// RestoreTask re-attaches a task loaded from disk to the queue.
+func (queue *Queue) RestoreTask(s task.State) {
+	t := task.Restore(s, queue.limiter)
+	queue.configure(t)
+	queue.mutex.Lock()
+	queue.tasks = append(queue.tasks, t)
+	queue.mutex.Unlock()
+	queue.notify()
+}
+
+// configure gives t the queue's settings.
+func (queue *Queue) configure(t *task.Task) {
+	t.AttachBus(queue.events)
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	t.SetDiskOptions(queue.Preallocate, queue.PauseOnLowSpace)
+	t.SetPersistence(queue.stateDir, queue.Name)
+	t.SetClient(queue.client)
+	t.SetQueueRequestOptions(queue.request, queue.jar)
+	t.SetNetrc(queue.netrc)
+}
+
+// maxSchedulerSleep bounds how long Run waits without looking at the clock.
+// Timers follow monotonic time, which stands still while the machine is
+// suspended, so a long timer alone could fire hours late.
+const maxSchedulerSleep = time.Minute
+
+// clock tells Run the time. Tests move it to just before a cron time.
+var clock = time.Now
+
+// Run starts pending tasks, at most MaxDownloads at a time, until Stop is
+// called. Outside the queue's windows nothing starts: tasks running when a
+// window ends are suspended and start again in the next window. A cron
+// schedule starts the queue at its times, and it runs until nothing is left
+// to download, tasks waiting on their start time included. Run sleeps until
+// the next of these times or a change to the queue or its tasks.
 func (queue *Queue) Run() {
-	if queue.activeInterval != nil {
-		queue.activeInterval.WaitUntil()
-		queue.ctx, queue.cancelFunc = context.WithDeadline(context.Background(), queue.activeInterval.EndTime())
-	} else {
-		queue.ctx, queue.cancelFunc = context.WithCancel(context.Background())
-	}
-	defer queue.cancelFunc()
+	events := queue.events.Subscribe(64)
+	defer queue.events.Unsubscribe(events)
 
-	slog.Info(fmt.Sprintf("queue %s | starting tasks...", queue.Directory))
+	slog.Info(fmt.Sprintf("queue %s | starting tasks...", queue.directory()))
+	open := false
+	var (
+		cron     *utils.Cron
+		nextFire time.Time
+	)
 	for {
-		select {
-		case <-queue.ctx.Done():
-			slog.Info(fmt.Sprintf("queue %s | stopping tasks...", queue.Directory))
-			for _, t := range queue.tasks {
-				if t.Status() == task.InProgress {
-					t.Pause()
-				}
+		now := clock()
+		wasOpen := open
+		var wake time.Time
+		schedule, current := queue.timing()
+		switch {
+		case current != nil:
+			if current != cron {
+				cron, nextFire = current, current.Next(now)
+			}
+			if !nextFire.IsZero() && !now.Before(nextFire) {
+				slog.Info(fmt.Sprintf("queue %s | scheduled start", queue.directory()))
+				open, nextFire = true, cron.Next(now)
+			}
+			wake = nextFire
+		case schedule != nil:
+			var until time.Time
+			until, open = schedule.Active(now)
+			if open {
+				wake = until
+			} else {
+				wake = schedule.Next(now)
 			}
-			break
-
 		default:
-			done := true
-			downloadCount := uint8(0)
-			for _, t := range queue.tasks {
-				if downloadCount >= queue.MaxDownloads {
-					break
-				}
+			open = true
+		}
+		if current == nil {
+			cron = nil
+		}
 
+		if wasOpen && !open {
+			slog.Info(fmt.Sprintf("queue %s | window closed, suspending tasks...", queue.directory()))
+			for _, t := range queue.Tasks() {
 				if t.Status() == task.InProgress {
-					downloadCount++
-					done = false
-				} else if t.Status() == task.Pending {
-					slog.Info(fmt.Sprintf("queue %s | starting task %s...", queue.Directory, t.Url()))
-					downloadCount++
-					done = false
-					t.Resume()
+					t.Suspend()
 				}
 			}
+		} else if open && !wasOpen && schedule != nil {
+			slog.Info(fmt.Sprintf("queue %s | window open until %s", queue.directory(), wake.Format(time.DateTime)))
+		}
 
-			if done {
-				break
+		if open {
+			startAt, idle := queue.startTasks(now)
+			wake = earliest(wake, startAt)
+			// Tasks waiting on their start time keep the session open
+			if idle && startAt.IsZero() && cron != nil {
+				slog.Info(fmt.Sprintf("queue %s | done until %s", queue.directory(), nextFire.Format(time.DateTime)))
+				open = false
 			}
-			time.Sleep(time.Second)
 		}
+
+		if !queue.wait(events, wake) {
+			// Stop suspends the tasks itself
+			slog.Info(fmt.Sprintf("queue %s | stopping tasks...", queue.directory()))
+			return
+		}
+	}
+}
+
+// startTasks starts pending tasks while fewer than MaxDownloads run. It
+// returns the earliest start time of the tasks that must wait for one, and
+// whether the queue has nothing to do now.
+func (queue *Queue) startTasks(now time.Time) (startAt time.Time, idle bool) {
+	queue.mutex.Lock()
+	maxDownloads := queue.MaxDownloads
+	queue.mutex.Unlock()
+	running := uint8(0)
+	for _, t := range queue.Tasks() {
+		if t.Status() == task.InProgress {
+			running++
+		}
+	}
+	for _, t := range queue.Tasks() {
+		if t.Status() != task.Pending {
+			continue
+		}
+		if at := t.StartAt(); at.After(now) {
+			startAt = earliest(startAt, at)
+			continue
+		}
+		if running >= maxDownloads {
+			break
+		}
+		slog.Info(fmt.Sprintf("queue %s | starting task %s...", queue.directory(), utils.RedactURL(t.Url())))
+		running++
+		t.Resume()
 	}
+	return startAt, running == 0
 }
 
+// wait sleeps until wake, a change to the queue or to the status of one of
+// its tasks. It returns false once the queue is stopped.
+func (queue *Queue) wait(events <-chan task.Event, wake time.Time) bool {
+	d := maxSchedulerSleep
+	if !wake.IsZero() {
+		d = min(d, wake.Sub(clock()))
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-queue.ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-queue.wake:
+			return true
+		case e := <-events:
+			if _, ok := e.(task.StatusChanged); ok {
+				return true
+			}
+		}
+	}
+}
+
+// notify wakes Run to look at the queue again.
+func (queue *Queue) notify() {
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// earliest returns the earlier of two times, ignoring zero ones.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// Stop halts the queue and suspends its running tasks, saving their state.
 func (queue *Queue) Stop() {
 	queue.cancelFunc()
+	for _, t := range queue.Tasks() {
+		t.Suspend()
+	}
 }
+
+// Delete stops the queue for good: its unfinished downloads are canceled and
+// none of its tasks is restored on the next launch.
+func (queue *Queue) Delete() {
+	queue.cancelFunc()
+	for _, t := range queue.Tasks() {
+		t.Remove()
+	}
+}
+
+// Subscribe returns a channel receiving the events of every task in the queue.
+func (queue *Queue) Subscribe(buffer int) <-chan task.Event {
+	return queue.events.Subscribe(buffer)
+}
+
+// Unsubscribe stops delivery to a channel returned by Subscribe.
+func (queue *Queue) Unsubscribe(ch <-chan task.Event) {
+	queue.events.Unsubscribe(ch)
+}
+
+// Tasks returns a snapshot of the queue's tasks.
 func (queue *Queue) Tasks() []*task.Task {
-	return queue.tasks
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return append([]*task.Task(nil), queue.tasks...)
 }
 
 func (queue *Queue) SetName(name string) {
+	queue.mutex.Lock()
 	queue.Name = name
+	dir := queue.stateDir
+	queue.mutex.Unlock()
+	for _, t := range queue.Tasks() {
+		t.SetPersistence(dir, name)
+	}
+}
+
+// SetStateDir sets where the queue's tasks persist their state.
+func (queue *Queue) SetStateDir(dir string) {
+	queue.mutex.Lock()
+	queue.stateDir = dir
+	name := queue.Name
+	queue.mutex.Unlock()
+	for _, t := range queue.Tasks() {
+		t.SetPersistence(dir, name)
+	}
 }
 func (queue *Queue) SetDirectory(folder string) error {
+	dir := folder
 	info, err := os.Stat(folder)
 	if err != nil || !info.IsDir() {
 		dirname, err := os.UserHomeDir()
@@ -164,46 +383,162 @@ func (queue *Queue) SetDirectory(folder string) error {
 			return fmt.Errorf("failed to get user home directory: %w", err)
 		}
 
-		queue.Directory = filepath.Join(dirname, folder)
+		dir = filepath.Join(dirname, folder)
 
-		if _, err := os.Stat(queue.Directory); os.IsNotExist(err) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
 			slog.Error(fmt.Sprintf("folder does not exist: %v", folder))
 			return fmt.Errorf("folder does not exist: %s", folder)
 		}
 
 	}
+	queue.mutex.Lock()
+	queue.Directory = dir
+	queue.mutex.Unlock()
+	return nil
+}
+
+// directory returns where the queue saves downloads by default.
+func (queue *Queue) directory() string {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.Directory
+}
+
+// SetHTTPOptions replaces the client the queue's tasks download with.
+func (queue *Queue) SetHTTPOptions(opts utils.HTTPOptions) error {
+	client, err := utils.NewHTTPClient(opts)
+	if err != nil {
+		return err
+	}
+	queue.mutex.Lock()
+	queue.client = client
+	queue.mutex.Unlock()
+	for _, t := range queue.Tasks() {
+		t.SetClient(client)
+	}
+	return nil
+}
+
+// SetRequestOptions sets headers, credentials and cookies sent by every task
+// of the queue. The cookies are loaded into one jar all the tasks share.
+func (queue *Queue) SetRequestOptions(opts task.RequestOptions) error {
+	var jar http.CookieJar
+	if opts.CookieFile != "" {
+		var err error
+		if jar, err = task.LoadCookieFile(opts.CookieFile); err != nil {
+			return fmt.Errorf("load cookies: %w", err)
+		}
+	}
+	queue.mutex.Lock()
+	queue.request = opts
+	queue.jar = jar
+	queue.mutex.Unlock()
+	for _, t := range queue.Tasks() {
+		t.SetQueueRequestOptions(opts, jar)
+	}
 	return nil
 }
 
+// SetNetrc makes the queue's tasks log in with the credentials .netrc has
+// for their host, unless they were given some.
+func (queue *Queue) SetNetrc(netrc *utils.Netrc) {
+	queue.mutex.Lock()
+	queue.netrc = netrc
+	queue.mutex.Unlock()
+	for _, t := range queue.Tasks() {
+		t.SetNetrc(netrc)
+	}
+}
+
+func (queue *Queue) RequestOptions() task.RequestOptions {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return queue.request
+}
+
 func (queue *Queue) SetMaxDownloads(n uint8) {
+	queue.mutex.Lock()
 	queue.MaxDownloads = n
+	queue.mutex.Unlock()
+	queue.notify()
 }
+// SetSpeedLimit sets the queue's bandwidth in KB/s, 0 for unlimited. Running
+// tasks slow down or speed up right away.
 func (queue *Queue) SetSpeedLimit(limit uint64) {
-	queue.limiter = utils.CreateLimiter(limit)
+	queue.mutex.Lock()
+	queue.SpeedLimit = limit
+	queue.mutex.Unlock()
+	queue.limiter.SetRate(limit * 1024)
 }
 
-func parseUserInterval(input string) (*utils.TimeInterval, error) {
-	parts := strings.Split(input, "-")
-	if len(parts) != 2 {
-		return nil, errors.New("invalid interval format (expected 08:00:00-17:00:00)")
-	}
-	start := strings.TrimSpace(parts[0])
-	end := strings.TrimSpace(parts[1])
+// SetBandwidthSchedule varies the queue's bandwidth by time of day. Outside
+// the schedule's rules the speed limit applies; nil removes the schedule.
+func (queue *Queue) SetBandwidthSchedule(schedule *utils.BandwidthSchedule) {
+	queue.limiter.SetSchedule(schedule)
+}
 
-	ti, err := utils.NewTimeInterval(start, end)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse time interval: %w", err)
-	}
-	return ti, nil
+func (queue *Queue) BandwidthSchedule() *utils.BandwidthSchedule {
+	return queue.limiter.Schedule()
+}
+
+// CurrentSpeedLimit returns the limit in force now in KB/s, which the
+// bandwidth schedule may set.
+func (queue *Queue) CurrentSpeedLimit() uint64 {
+	return queue.limiter.CurrentRate() / 1024
+}
+
+// SetGlobalLimiter puts the queue under a limiter shared with other queues.
+// Busy queues get an even share of it.
+func (queue *Queue) SetGlobalLimiter(limiter *utils.Limiter) {
+	queue.limiter.SetParent(limiter)
 }
+
+// SetActiveIntervalFromString sets when the queue runs from windows such as
+// "Mon-Fri 22:00-06:00, Sat-Sun all day", a cron expression of its start
+// times such as "0 22 * * 1-5", or "always".
 func (q *Queue) SetActiveIntervalFromString(input string) error {
-	if input == "always"|| input == "Always" {
-	return nil
-	}
-	ti, err := parseUserInterval(input)
-	if err != nil {
-		return err
+	input = strings.TrimSpace(input)
+	var (
+		schedule *utils.Schedule
+		cron     *utils.Cron
+		err      error
+	)
+	if !strings.EqualFold(input, "always") && input != "" {
+		if cron, err = utils.ParseCron(input); err != nil {
+			var cronErr error
+			cron, cronErr = nil, err
+			if schedule, err = utils.ParseSchedule(input); err != nil {
+				// Report the error of the syntax it looks like: windows start
+				// with days or a time of day
+				if !strings.Contains(input, ":") && strings.IndexAny(input, "0123456789*@") == 0 || strings.HasPrefix(input, "CRON_TZ=") {
+					return fmt.Errorf("failed to parse cron expression: %w", cronErr)
+				}
+				return fmt.Errorf("failed to parse time window: %w", err)
+			}
+		}
 	}
-	q.activeInterval = ti
+	q.mutex.Lock()
+	q.schedule, q.cron = schedule, cron
+	q.mutex.Unlock()
+	q.notify()
 	return nil
 }
+
+// TimeWindow describes when the queue runs, as SetActiveIntervalFromString
+// takes it.
+func (q *Queue) TimeWindow() string {
+	schedule, cron := q.timing()
+	if cron != nil {
+		return cron.String()
+	} else if schedule != nil {
+		return schedule.String()
+	}
+	return "Always"
+}
+
+// timing returns the windows and the cron schedule the queue runs by.
+func (q *Queue) timing() (*utils.Schedule, *utils.Cron) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.schedule, q.cron
+}
@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sharif-go-lab/go-download-manager/internal/task"
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
+)
+
+// Run with -race: the TUI changes the queue while Run reads it.
+func TestSettersWhileRunning(t *testing.T) {
+	dir := t.TempDir()
+	queue := NewQueue("Default", dir, 2, 1, 0, 0, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.Run()
+	}()
+
+	for i := 0; i < 50; i++ {
+		queue.SetMaxDownloads(uint8(i%3 + 1))
+		queue.SetSpeedLimit(uint64(i * 10))
+		queue.SetName("Default")
+		queue.SetStateDir(t.TempDir())
+		queue.SetNetrc(&utils.Netrc{})
+		if err := queue.SetDirectory(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := queue.SetHTTPOptions(utils.DefaultHTTPOptions()); err != nil {
+			t.Fatal(err)
+		}
+		if err := queue.SetRequestOptions(task.RequestOptions{Header: http.Header{"X-Try": {"1"}}}); err != nil {
+			t.Fatal(err)
+		}
+		_ = queue.State()
+	}
+	queue.Stop()
+	<-done
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Unix(1000, 0), bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// run starts the queue's Run and stops it when the test ends.
+func run(t *testing.T, queue *Queue) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.Run()
+	}()
+	t.Cleanup(func() {
+		queue.Stop()
+		<-done
+	})
+}
+
+// waitStatus waits for the task to reach status.
+func waitStatus(t *testing.T, tk *task.Task, status task.DownloadStatus) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for tk.Status() != status {
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s is %v, want %v (last error: %v)", tk.Url(), tk.Status(), status, tk.LastError())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRunWaitsForStartAt(t *testing.T) {
+	srv := newServer(t)
+	queue := NewQueue("Default", t.TempDir(), 3, 1, 0, 0, nil)
+	startAt := time.Now().Add(300 * time.Millisecond)
+	if err := queue.AddTask(srv.URL+"/later.bin", "", "", task.RequestOptions{}, startAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.AddTask(srv.URL+"/now.bin", "", "", task.RequestOptions{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	run(t, queue)
+
+	later, now := queue.Tasks()[0], queue.Tasks()[1]
+	waitStatus(t, now, task.Completed)
+	if status := later.Status(); status != task.Pending {
+		t.Fatalf("task started before its start time, status %v", status)
+	}
+	// Nothing else happens in the queue: Run must wake up for it
+	waitStatus(t, later, task.Completed)
+	if time.Now().Before(startAt) {
+		t.Fatal("task completed before its start time")
+	}
+}
+
+func TestRunCronSession(t *testing.T) {
+	// Run's clock is 300ms before the next minute, when the cron fires
+	offset := time.Until(time.Now().Truncate(time.Minute).Add(time.Minute - 300*time.Millisecond))
+	clock = func() time.Time { return time.Now().Add(offset) }
+	t.Cleanup(func() { clock = time.Now })
+	fire := clock().Add(300 * time.Millisecond).Truncate(time.Minute)
+
+	srv := newServer(t)
+	queue := NewQueue("Nightly", t.TempDir(), 3, 1, 0, 0, nil)
+	if err := queue.SetActiveIntervalFromString("* * * * *"); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.AddTask(srv.URL+"/first.bin", "", "", task.RequestOptions{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// A task waiting on its start time keeps the session open
+	if err := queue.AddTask(srv.URL+"/second.bin", "", "", task.RequestOptions{}, fire.Add(300*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	run(t, queue)
+
+	time.Sleep(150 * time.Millisecond)
+	first, second := queue.Tasks()[0], queue.Tasks()[1]
+	if status := first.Status(); status != task.Pending {
+		t.Fatalf("task started before the cron time, status %v", status)
+	}
+	waitStatus(t, first, task.Completed)
+	waitStatus(t, second, task.Completed)
+
+	// With nothing left to do the session is over until the next minute
+	if err := queue.AddTask(srv.URL+"/third.bin", "", "", task.RequestOptions{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if status := queue.Tasks()[2].Status(); status != task.Pending {
+		t.Fatalf("task started after the session closed, status %v", status)
+	}
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sharif-go-lab/go-download-manager/internal/task"
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
+)
+
+// State is everything needed to rebuild a Queue after a restart. Its tasks
+// are saved on their own.
+type State struct {
+	Name              string `json:"name"`
+	Directory         string `json:"directory"`
+	MaxDownloads      uint8  `json:"max_downloads"`
+	Threads           uint8  `json:"threads"`
+	Retries           uint8  `json:"retries"`
+	SpeedLimit        uint64 `json:"speed_limit,omitempty"` // KB/s
+	BandwidthSchedule string `json:"bandwidth_schedule,omitempty"`
+	TimeWindow        string `json:"time_window,omitempty"`
+	Preallocate       bool   `json:"preallocate"`
+	PauseOnLowSpace   bool   `json:"pause_on_low_space,omitempty"`
+
+	Request *task.RequestOptions `json:"request,omitempty"`
+}
+
+// DefaultStatePath returns the file where the queues are kept.
+func DefaultStatePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-download-manager", "queues.json"), nil
+}
+
+// State returns the queue's settings suitable for persisting.
+func (queue *Queue) State() State {
+	queue.mutex.Lock()
+	s := State{
+		Name:            queue.Name,
+		Directory:       queue.Directory,
+		MaxDownloads:    queue.MaxDownloads,
+		Threads:         queue.Threads,
+		Retries:         queue.Retries,
+		SpeedLimit:      queue.SpeedLimit,
+		Preallocate:     queue.Preallocate,
+		PauseOnLowSpace: queue.PauseOnLowSpace,
+	}
+	queue.mutex.Unlock()
+	s.TimeWindow = queue.TimeWindow()
+	if schedule := queue.BandwidthSchedule(); schedule != nil {
+		s.BandwidthSchedule = schedule.String()
+	}
+	if request := queue.RequestOptions(); !request.Empty() {
+		s.Request = &request
+	}
+	return s
+}
+
+// Restore rebuilds a queue from its saved settings. A setting that no longer
+// parses is dropped, so the rest of the queue still comes back.
+func Restore(s State) (*Queue, error) {
+	queue := NewQueue(s.Name, s.Directory, s.MaxDownloads, s.Threads, s.Retries, s.SpeedLimit, nil)
+	queue.Preallocate = s.Preallocate
+	queue.PauseOnLowSpace = s.PauseOnLowSpace
+
+	var errs []error
+	if schedule, err := utils.ParseBandwidthSchedule(s.BandwidthSchedule); err != nil {
+		errs = append(errs, fmt.Errorf("bandwidth schedule: %w", err))
+	} else {
+		queue.SetBandwidthSchedule(schedule)
+	}
+	if err := queue.SetActiveIntervalFromString(s.TimeWindow); err != nil {
+		errs = append(errs, err)
+	}
+	if s.Request != nil {
+		if err := queue.SetRequestOptions(*s.Request); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return queue, errors.Join(errs...)
+}
+
+// SaveStates atomically writes the queues to path. They may hold
+// credentials, so only the user can read them.
+func SaveStates(path string, states []State) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode queues: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write queues: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadStates reads the queues saved at path, in the order they were shown.
+// It returns nil if none were saved yet.
+func LoadStates(path string) ([]State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var states []State
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("decode queues: %w", err)
+	}
+	return states, nil
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sharif-go-lab/go-download-manager/internal/task"
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config", "queues.json")
+
+	videos := NewQueue("Videos", dir, 2, 4, 5, 300, nil)
+	videos.PauseOnLowSpace = true
+	schedule, err := utils.ParseBandwidthSchedule("09:00-18:00 200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	videos.SetBandwidthSchedule(schedule)
+	if err := videos.SetActiveIntervalFromString("Mon-Fri 22:00-06:00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := videos.SetRequestOptions(task.RequestOptions{
+		Header: http.Header{"X-Api-Key": {"abc"}},
+		Token:  "secret",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	nightly := NewQueue("Nightly", dir, 1, 1, 0, 0, nil)
+	if err := nightly.SetActiveIntervalFromString("30 2 * * *"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []State{videos.State(), nightly.State(), NewQueue("Default", dir, 3, 2, 3, 0, nil).State()}
+	if err := SaveStates(path, want); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("queue file mode = %o, want 600", perm)
+	}
+
+	states, err := LoadStates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(states, want) {
+		t.Fatalf("loaded\n%+v\nwant\n%+v", states, want)
+	}
+	for i, s := range states {
+		restored, err := Restore(s)
+		if err != nil {
+			t.Fatalf("Restore(%s): %v", s.Name, err)
+		}
+		if got := restored.State(); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("restored\n%+v\nwant\n%+v", got, want[i])
+		}
+	}
+	if got := states[1].TimeWindow; got != "30 2 * * *" {
+		t.Errorf("cron time window = %q", got)
+	}
+}
+
+func TestRestoreKeepsValidSettings(t *testing.T) {
+	restored, err := Restore(State{
+		Name:         "Broken",
+		Directory:    t.TempDir(),
+		MaxDownloads: 2,
+		SpeedLimit:   100,
+		TimeWindow:   "Mon-Fri 25:00-06:00",
+	})
+	if err == nil {
+		t.Fatal("Restore() accepted an invalid time window")
+	}
+	if restored.Name != "Broken" || restored.SpeedLimit != 100 || restored.TimeWindow() != "Always" {
+		t.Errorf("restored %+v", restored.State())
+	}
+}
+
+func TestLoadStatesMissingFile(t *testing.T) {
+	states, err := LoadStates(filepath.Join(t.TempDir(), "queues.json"))
+	if err != nil || states != nil {
+		t.Fatalf("LoadStates() = %v, %v, want nil, nil", states, err)
+	}
+}
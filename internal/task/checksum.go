@@ -0,0 +1,138 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Checksum is an expected digest of a downloaded file.
+type Checksum struct {
+	Algo string // md5, sha1, sha256 or sha512
+	Sum  []byte
+}
+
+// ChecksumError is returned when the downloaded file does not match.
+type ChecksumError struct {
+	Expected *Checksum
+	Actual   []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s mismatch: expected %s, got %s", e.Expected.Algo, hex.EncodeToString(e.Expected.Sum), hex.EncodeToString(e.Actual))
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// normalizeAlgo maps spellings such as "SHA-256" to the names used here.
+func normalizeAlgo(algo string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(algo)), "-", "")
+}
+
+// ParseChecksum parses a checksum given as algo:hex, e.g. "sha256:9f86d0...".
+func ParseChecksum(s string) (*Checksum, error) {
+	algo, sum, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid checksum %q (expected algo:hex)", s)
+	}
+	return newChecksum(algo, strings.TrimSpace(sum), hex.DecodeString)
+}
+
+func newChecksum(algo, encoded string, decode func(string) ([]byte, error)) (*Checksum, error) {
+	algo = normalizeAlgo(algo)
+	h := newHash(algo)
+	if h == nil {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	sum, err := decode(encoded)
+	if err != nil || len(sum) != h.Size() {
+		return nil, fmt.Errorf("invalid %s checksum %q", algo, encoded)
+	}
+	return &Checksum{Algo: algo, Sum: sum}, nil
+}
+
+func (c *Checksum) String() string {
+	return c.Algo + ":" + hex.EncodeToString(c.Sum)
+}
+
+// Verify hashes the file at path and compares it to the expected sum.
+func (c *Checksum) Verify(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := newHash(c.Algo)
+	if _, err := io.Copy(h, &contextReader{ctx: ctx, r: file}); err != nil {
+		return err
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, c.Sum) {
+		return &ChecksumError{Expected: c, Actual: sum}
+	}
+	return nil
+}
+
+// contextReader stops reading once ctx is done, so hashing a large file does
+// not hold up a pause.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// checksumFromHeaders picks the strongest digest the server advertises in
+// Repr-Digest, Digest or Content-MD5. Content-MD5 describes the body of the
+// response only, so it is ignored for partial responses.
+func checksumFromHeaders(header http.Header, partial bool) *Checksum {
+	digests := make(map[string]string)
+	for _, name := range []string{"Digest", "Repr-Digest"} {
+		for _, field := range strings.Split(header.Get(name), ",") {
+			algo, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			if ok {
+				digests[normalizeAlgo(algo)] = strings.Trim(value, ":")
+			}
+		}
+	}
+	if md5sum := header.Get("Content-MD5"); md5sum != "" && !partial {
+		digests["md5"] = md5sum
+	}
+
+	for _, algo := range []string{"sha512", "sha256", "sha1", "md5"} {
+		if value, ok := digests[algo]; ok {
+			if c, err := newChecksum(algo, value, base64.StdEncoding.DecodeString); err == nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
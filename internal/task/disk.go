@@ -0,0 +1,91 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
+)
+
+const lowSpaceNotice = "paused: disk is full"
+
+// DiskSpaceError is returned when the target filesystem cannot hold the file.
+type DiskSpaceError struct {
+	Needed    uint64
+	Available uint64
+}
+
+func (e *DiskSpaceError) Error() string {
+	return fmt.Sprintf("insufficient disk space: need %d bytes, %d available", e.Needed, e.Available)
+}
+
+// SetDiskOptions controls whether the file is preallocated before downloading
+// and whether running out of space mid-download pauses the task instead of
+// failing it.
+func (t *Task) SetDiskOptions(preallocate, pauseOnLowSpace bool) {
+	t.mutex.Lock()
+	t.preallocate = preallocate
+	t.pauseOnLowSpace = pauseOnLowSpace
+	t.mutex.Unlock()
+}
+
+// checkSpace makes sure the rest of the file fits on the disk before any
+// worker starts. A part file that was already preallocated needs nothing more.
+func (t *Task) checkSpace() error {
+	if t.fileSize <= 0 {
+		return nil
+	}
+	if info, err := os.Stat(t.partPath()); err == nil && t.preallocate && info.Size() >= t.fileSize {
+		return nil
+	}
+
+	available, err := utils.FreeSpace(filepath.Dir(t.filePath))
+	if err != nil {
+		// Not knowing is no reason to refuse the download
+		slog.Debug(fmt.Sprintf("task %s | free space check skipped: %v", t.filePath, err))
+		return nil
+	}
+	needed := uint64(t.fileSize) - t.Downloaded()
+	if needed > available {
+		return &DiskSpaceError{Needed: needed, Available: available}
+	}
+	return nil
+}
+
+// allocate reserves the whole file up front where the platform allows it.
+func (t *Task) allocate(file *os.File) error {
+	if !t.preallocate || t.fileSize <= 0 {
+		return nil
+	}
+	err := utils.Preallocate(file, t.fileSize)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return err
+}
+
+func diskFull(err error) bool {
+	var spaceErr *DiskSpaceError
+	return errors.Is(err, syscall.ENOSPC) || errors.As(err, &spaceErr)
+}
+
+// pauseForSpace parks a task whose disk filled up, if its queue asked for
+// that, so it can be resumed once space is freed.
+func (t *Task) pauseForSpace(err error) bool {
+	if !t.pauseOnLowSpace || !diskFull(err) {
+		return false
+	}
+	slog.Warn(fmt.Sprintf("task %s | pausing: %v", t.filePath, err))
+	t.mutex.Lock()
+	if t.status == InProgress {
+		t.setStatus(Paused)
+	}
+	t.notice = lowSpaceNotice
+	t.mutex.Unlock()
+	t.persist()
+	return true
+}
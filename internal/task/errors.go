@@ -0,0 +1,127 @@
+package task
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"syscall"
+	"time"
+)
+
+// maxErrorHistory is how many errors a task remembers.
+const maxErrorHistory = 20
+
+// ErrorKind tells what part of a download went wrong.
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindDNS
+	KindTLS
+	KindNetwork
+	KindHTTPStatus
+	KindDisk
+	KindChecksum
+	KindCanceled
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindDNS:
+		return "DNS"
+	case KindTLS:
+		return "TLS"
+	case KindNetwork:
+		return "Network"
+	case KindHTTPStatus:
+		return "HTTP"
+	case KindDisk:
+		return "Disk"
+	case KindChecksum:
+		return "Checksum"
+	case KindCanceled:
+		return "Canceled"
+	}
+	return "Unknown"
+}
+
+// ClassifyError works out the kind of err from the errors it wraps.
+func ClassifyError(err error) ErrorKind {
+	var (
+		dnsErr      *net.DNSError
+		httpErr     *HTTPError
+		checksumErr *ChecksumError
+		spaceErr    *DiskSpaceError
+		pathErr     *fs.PathError
+		netErr      net.Error
+	)
+	switch {
+	case err == nil:
+		return KindUnknown
+	case errors.Is(err, context.Canceled):
+		return KindCanceled
+	case errors.As(err, &dnsErr):
+		return KindDNS
+	case isTLSError(err):
+		return KindTLS
+	case errors.As(err, &httpErr):
+		return KindHTTPStatus
+	case errors.As(err, &checksumErr):
+		return KindChecksum
+	case errors.As(err, &spaceErr), errors.As(err, &pathErr), errors.Is(err, syscall.ENOSPC):
+		return KindDisk
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return KindNetwork
+	}
+	return KindUnknown
+}
+
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		verifyErr    *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		authorityErr x509.UnknownAuthorityError
+		hostErr      x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostErr) || errors.As(err, &invalidErr)
+}
+
+// TaskError is an entry of a task's error history.
+type TaskError struct {
+	Time time.Time
+	Kind ErrorKind
+	Err  error
+}
+
+func (e TaskError) Error() string {
+	return e.Kind.String() + ": " + e.Err.Error()
+}
+
+func (e TaskError) Unwrap() error {
+	return e.Err
+}
+
+// recordError adds err to the history. The caller must hold t.mutex.
+func (t *Task) recordError(err error) TaskError {
+	e := TaskError{time.Now(), ClassifyError(err), err}
+	t.errors = append(t.errors, e)
+	if len(t.errors) > maxErrorHistory {
+		t.errors = t.errors[len(t.errors)-maxErrorHistory:]
+	}
+	return e
+}
+
+// Errors returns the errors the task ran into, oldest first. Retried
+// attempts are included, not only the one that made the task fail.
+func (t *Task) Errors() []TaskError {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]TaskError(nil), t.errors...)
+}
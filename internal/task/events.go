@@ -0,0 +1,153 @@
+package task
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something that happened to a task. Subscribers switch on the
+// concrete type: StatusChanged, Progress, SegmentRetry, DownloadCompleted or
+// DownloadFailed.
+type Event interface {
+	Task() *Task
+}
+
+type taskEvent struct {
+	task *Task
+}
+
+func (e taskEvent) Task() *Task {
+	return e.task
+}
+
+// StatusChanged is sent on every status transition.
+type StatusChanged struct {
+	taskEvent
+	From DownloadStatus
+	To   DownloadStatus
+}
+
+// Progress is sent about once a second while the task downloads.
+type Progress struct {
+	taskEvent
+	Downloaded uint64
+	Total      int64  // -1 if unknown
+	Speed      uint64 // bytes per second
+}
+
+// SegmentRetry is sent when a connection failed and is about to be retried.
+type SegmentRetry struct {
+	taskEvent
+	Thread  int
+	Attempt uint8
+	Delay   time.Duration
+	Err     error
+}
+
+// DownloadCompleted is sent once the file is in place under its final name.
+type DownloadCompleted struct {
+	taskEvent
+}
+
+// DownloadFailed is sent when the task gives up, including on a checksum
+// mismatch.
+type DownloadFailed struct {
+	taskEvent
+	Err error
+}
+
+// Bus fans events out to subscribers. Sending never blocks the download: a
+// subscriber that falls behind its buffer misses events.
+type Bus struct {
+	mutex       sync.Mutex
+	subscribers map[<-chan Event]chan Event
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[<-chan Event]chan Event)}
+}
+
+// Subscribe returns a channel receiving every event from now on.
+func (b *Bus) Subscribe(buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	b.mutex.Lock()
+	b.subscribers[ch] = ch
+	b.mutex.Unlock()
+	return ch
+}
+
+// Unsubscribe stops delivery to ch and closes it.
+func (b *Bus) Unsubscribe(ch <-chan Event) {
+	b.mutex.Lock()
+	if sub, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(sub)
+	}
+	b.mutex.Unlock()
+}
+
+func (b *Bus) publish(e Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, sub := range b.subscribers {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events of this task.
+func (t *Task) Subscribe(buffer int) <-chan Event {
+	return t.events.Subscribe(buffer)
+}
+
+// Unsubscribe stops delivery to a channel returned by Subscribe.
+func (t *Task) Unsubscribe(ch <-chan Event) {
+	t.events.Unsubscribe(ch)
+}
+
+// AttachBus also sends the task's events to b, typically its queue's bus.
+func (t *Task) AttachBus(b *Bus) {
+	t.queueEvents.Store(b)
+}
+
+func (t *Task) emit(e Event) {
+	t.events.publish(e)
+	if b := t.queueEvents.Load(); b != nil {
+		b.publish(e)
+	}
+}
+
+// setStatus moves the task to status and tells subscribers. The caller must
+// hold t.mutex.
+func (t *Task) setStatus(status DownloadStatus) {
+	from := t.status
+	if from == status {
+		return
+	}
+	t.status = status
+	t.emit(StatusChanged{taskEvent{t}, from, status})
+
+	switch status {
+	case Completed:
+		t.emit(DownloadCompleted{taskEvent{t}})
+	case Failed, VerificationFailed:
+		t.emit(DownloadFailed{taskEvent{t}, t.lastErr})
+	}
+}
+
+// emitProgress samples the transfer rate and tells subscribers how far the
+// download got.
+func (t *Task) emitProgress() {
+	t.mutex.Lock()
+	t.sampleRate(time.Now())
+	var downloaded uint64
+	for _, seg := range t.segments {
+		downloaded += seg.downloaded
+	}
+	total := t.fileSize
+	speed := uint64(t.rate.rate)
+	t.mutex.Unlock()
+	t.emit(Progress{taskEvent{t}, downloaded, total, speed})
+}
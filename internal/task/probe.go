@@ -0,0 +1,183 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
+)
+
+// HTTPError is returned when the server answers with an error status instead
+// of the file.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // from the Retry-After header of a 429 or 503
+}
+
+func newHTTPError(resp *http.Response) *HTTPError {
+	e := &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("server responded %s", e.Status)
+}
+
+// Temporary reports whether asking again later may succeed.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// remoteInfo is what the metadata probe learns about the remote file.
+type remoteInfo struct {
+	resp         *http.Response // used to derive the file name
+	size         int64          // -1 if unknown
+	etag         string
+	lastModified string
+	ranges       bool
+	checksum     *Checksum // advertised by the server, if any
+}
+
+func newRemoteInfo(resp *http.Response, size int64) *remoteInfo {
+	return &remoteInfo{
+		resp:         resp,
+		size:         size,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		checksum:     checksumFromHeaders(resp.Header, resp.StatusCode == http.StatusPartialContent),
+	}
+}
+
+// do sends req with the task's client, or the default one if it has none.
+func (t *Task) do(req *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	client, jar := t.client, t.jar
+	if jar == nil {
+		jar = t.queueJar
+	}
+	t.mutex.Unlock()
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if jar != nil {
+		// Share the transport, and with it the connections, of the client
+		withJar := *client
+		withJar.Jar = jar
+		client = &withJar
+	}
+	resp, err := client.Do(req)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// The error ends up in the log and the error history
+		urlErr.URL = utils.RedactURL(urlErr.URL)
+	}
+	return resp, err
+}
+
+func (t *Task) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	return t.newRequestTo(ctx, method, t.url)
+}
+
+// newRequestTo builds a request for a URL related to the task, such as a
+// checksum file next to it.
+func (t *Task) newRequestTo(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.applyOptions(req)
+	return req, nil
+}
+
+// probe learns the size, validators and range support of the remote file. It
+// starts with HEAD and falls back to fetching the first byte with GET, since
+// many CDNs and presigned URLs reject HEAD but serve GET fine.
+func (t *Task) probe(ctx context.Context) (*remoteInfo, error) {
+	req, err := t.newRequest(ctx, http.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		info := newRemoteInfo(resp, resp.ContentLength)
+		info.ranges = t.supportsRanges(ctx, resp)
+		return info, nil
+	}
+	slog.Debug(fmt.Sprintf("task %s | head url %s rejected with %s, trying get", t.filePath, utils.RedactURL(t.url), resp.Status))
+
+	req, err = t.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err = t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		info := newRemoteInfo(resp, contentRangeSize(resp.Header.Get("Content-Range")))
+		info.ranges = true
+		return info, nil
+	case http.StatusOK:
+		return newRemoteInfo(resp, resp.ContentLength), nil
+	default:
+		return nil, newHTTPError(resp)
+	}
+}
+
+// supportsRanges reports whether the server can serve parts of the file. It
+// trusts an explicit Accept-Ranges header and probes the server otherwise.
+func (t *Task) supportsRanges(ctx context.Context, resp *http.Response) bool {
+	switch strings.ToLower(resp.Header.Get("Accept-Ranges")) {
+	case "bytes":
+		return true
+	case "none":
+		return false
+	}
+
+	req, err := t.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	probe, err := t.do(req)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("task %s | range probe failed: %v", t.filePath, err))
+		return false
+	}
+	probe.Body.Close()
+	return probe.StatusCode == http.StatusPartialContent
+}
+
+// contentRangeSize extracts the complete length from a header such as
+// "bytes 0-0/1234", returning -1 if it is missing or unknown.
+func contentRangeSize(header string) int64 {
+	i := strings.LastIndex(header, "/")
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(header[i+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
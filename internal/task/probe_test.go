@@ -0,0 +1,75 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProbeFallsBackToGet(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, r.Method+" "+r.Header.Get("Range"))
+		mutex.Unlock()
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Range", "bytes 0-0/1234")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("x"))
+	}))
+	defer srv.Close()
+
+	task := NewTask(srv.URL+"/file.bin", t.TempDir(), 1, 0, nil)
+	info, err := task.probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.size != 1234 || !info.ranges || info.etag != `"v1"` {
+		t.Errorf("probe = size %d, ranges %v, etag %s", info.size, info.ranges, info.etag)
+	}
+	if got := strings.Join(requests, ", "); got != "HEAD , GET bytes=0-0" {
+		t.Errorf("requests = %s", got)
+	}
+}
+
+func TestProbeNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	task := NewTask(srv.URL+"/file.bin", t.TempDir(), 1, 0, nil)
+	_, err := task.probe(context.Background())
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("probe error = %v, want an HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusNotFound || httpErr.Temporary() {
+		t.Errorf("HTTPError = %d, temporary %v", httpErr.StatusCode, httpErr.Temporary())
+	}
+	if retryable(err) {
+		t.Error("a 404 must not be retried")
+	}
+}
+
+func TestRequestErrorRedactsURL(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close() // nothing listens there any more
+
+	rawURL := strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/file.bin?token=secret"
+	task := NewTask(rawURL, t.TempDir(), 1, 0, nil)
+	_, err := task.probe(context.Background())
+	if err == nil {
+		t.Fatal("probe of a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks credentials: %v", err)
+	}
+}
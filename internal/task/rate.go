@@ -0,0 +1,121 @@
+package task
+
+import "time"
+
+const (
+	// rateWindow is how far back the raw rate looks; rateSmoothing is the
+	// weight the EWMA gives each new window rate.
+	rateWindow    = 5 * time.Second
+	rateSmoothing = 0.3
+)
+
+type rateSample struct {
+	at    time.Time
+	bytes uint64
+}
+
+// rateMeter estimates a transfer rate. Each sample yields the average rate
+// over the last rateWindow, which is then smoothed with an exponentially
+// weighted moving average so the speed and ETA don't jump between ticks.
+type rateMeter struct {
+	samples []rateSample
+	rate    float64 // bytes per second
+	primed  bool
+}
+
+func (r *rateMeter) add(at time.Time, bytes uint64) {
+	if n := len(r.samples); n > 0 && bytes < r.samples[n-1].bytes {
+		// the counter went back, e.g. after a restart from scratch
+		r.reset()
+	}
+	r.samples = append(r.samples, rateSample{at, bytes})
+
+	cutoff := at.Add(-rateWindow)
+	drop := 0
+	for drop < len(r.samples)-2 && !r.samples[drop+1].at.After(cutoff) {
+		drop++
+	}
+	r.samples = r.samples[drop:]
+	if len(r.samples) < 2 {
+		return
+	}
+
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(last.bytes-first.bytes) / elapsed
+	if !r.primed {
+		r.rate = current
+		r.primed = true
+	} else {
+		r.rate += rateSmoothing * (current - r.rate)
+	}
+}
+
+func (r *rateMeter) reset() {
+	r.samples = r.samples[:0]
+	r.rate = 0
+	r.primed = false
+}
+
+// sampleRate records the current progress of the task and its segments. The
+// caller must hold t.mutex.
+func (t *Task) sampleRate(at time.Time) {
+	var downloaded uint64
+	for _, seg := range t.segments {
+		downloaded += seg.downloaded
+		if seg.active {
+			seg.rate.add(at, seg.downloaded)
+		}
+	}
+	t.rate.add(at, downloaded)
+}
+
+// Speed returns the smoothed download speed in bytes per second, or 0 if the
+// task isn't downloading.
+func (t *Task) Speed() uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.status != InProgress {
+		return 0
+	}
+	return uint64(t.rate.rate)
+}
+
+// SegmentSpeeds returns the speed of each segment being fetched, in bytes per
+// second.
+func (t *Task) SegmentSpeeds() []uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	speeds := make([]uint64, 0, len(t.segments))
+	if t.status != InProgress {
+		return speeds
+	}
+	for _, seg := range t.segments {
+		if seg.active {
+			speeds = append(speeds, uint64(seg.rate.rate))
+		}
+	}
+	return speeds
+}
+
+// ETA returns the estimated time left. It reports false while the size or
+// the speed is unknown.
+func (t *Task) ETA() (time.Duration, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.status != InProgress || t.fileSize < 0 || t.rate.rate < 1 {
+		return 0, false
+	}
+	var downloaded uint64
+	for _, seg := range t.segments {
+		downloaded += seg.downloaded
+	}
+	left := float64(t.fileSize) - float64(downloaded)
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(left / t.rate.rate * float64(time.Second)), true
+}
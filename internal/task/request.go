@@ -0,0 +1,205 @@
+package task
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
+)
+
+// RequestOptions are sent with every request of a task: the probe, each Range
+// request and checksum lookups. Queue options apply to all its tasks, and the
+// task's own take precedence over them.
+type RequestOptions struct {
+	Header http.Header `json:"header,omitempty"`
+
+	// Basic auth if Username is set, a Bearer token if Token is.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+
+	// CookieFile is a cookies.txt in Netscape format.
+	CookieFile string `json:"cookie_file,omitempty"`
+}
+
+// Empty reports whether the options add nothing to a request.
+func (o RequestOptions) Empty() bool {
+	return len(o.Header) == 0 && o.Username == "" && o.Token == "" && o.CookieFile == ""
+}
+
+func (o RequestOptions) hasAuth() bool {
+	return o.Username != "" || o.Token != ""
+}
+
+func (o RequestOptions) apply(req *http.Request) {
+	for name, values := range o.Header {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if o.Username != "" {
+		req.SetBasicAuth(o.Username, o.Password)
+	} else if o.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.Token)
+	}
+}
+
+// headerStart matches the "Name:" a header begins with.
+var headerStart = regexp.MustCompile("^\\s*[!#$%&'*+\\-.^_`|~0-9A-Za-z]+\\s*:")
+
+// ParseHeaders reads headers written as "Name: value", separated by new lines
+// or semicolons. A semicolon only separates headers if a "Name:" follows it,
+// so values such as "a=1; b=2" or "text/html;q=0.9" stay whole.
+func ParseHeaders(s string) (http.Header, error) {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		for i, part := range strings.Split(line, ";") {
+			if i > 0 && !headerStart.MatchString(part) {
+				lines[len(lines)-1] += ";" + part
+				continue
+			}
+			lines = append(lines, part)
+		}
+	}
+
+	header := make(http.Header)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q (expected Name: value)", line)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// LoadCookieFile reads a Netscape cookies.txt, as exported by browsers and
+// written by curl -c, into a new cookie jar.
+func LoadCookieFile(path string) (http.CookieJar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		} else if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s:%d: expected 7 tab separated fields", path, n)
+		}
+		domain, subdomains, cookiePath, secure := fields[0], fields[1] == "TRUE", fields[2], fields[3] == "TRUE"
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid expiry %q", path, n, fields[4])
+		}
+
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     cookiePath,
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if cookie.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+		host := strings.TrimPrefix(domain, ".")
+		if subdomains {
+			// A Domain attribute makes the jar send it to subdomains too
+			cookie.Domain = host
+		}
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookiePath}, []*http.Cookie{cookie})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return jar, nil
+}
+
+// SetRequestOptions sets the headers, credentials and cookies of the task.
+func (t *Task) SetRequestOptions(opts RequestOptions) error {
+	var jar http.CookieJar
+	if opts.CookieFile != "" {
+		var err error
+		if jar, err = LoadCookieFile(opts.CookieFile); err != nil {
+			return fmt.Errorf("load cookies: %w", err)
+		}
+	}
+	t.mutex.Lock()
+	t.request = opts
+	t.jar = jar
+	t.mutex.Unlock()
+	return nil
+}
+
+// SetQueueRequestOptions sets the options the task inherits from its queue.
+// The jar is shared by the queue's tasks, so a session cookie one of them
+// receives is sent by the others too.
+func (t *Task) SetQueueRequestOptions(opts RequestOptions, jar http.CookieJar) {
+	t.mutex.Lock()
+	t.queueRequest = opts
+	t.queueJar = jar
+	t.mutex.Unlock()
+}
+
+// SetNetrc sets the .netrc credentials used for hosts the task has no
+// credentials for.
+func (t *Task) SetNetrc(netrc *utils.Netrc) {
+	t.mutex.Lock()
+	t.netrc = netrc
+	t.mutex.Unlock()
+}
+
+// applyOptions adds the queue's and then the task's options to req. If
+// neither brings credentials, nor does the URL itself, .netrc may.
+func (t *Task) applyOptions(req *http.Request) {
+	t.mutex.Lock()
+	queueOpts, opts, netrc := t.queueRequest, t.request, t.netrc
+	t.mutex.Unlock()
+
+	if opts.hasAuth() {
+		queueOpts.Username, queueOpts.Token = "", ""
+	}
+	queueOpts.apply(req)
+	opts.apply(req)
+
+	if req.Header.Get("Authorization") == "" && req.URL.User == nil {
+		if login, password, ok := netrc.Lookup(req.URL.Hostname()); ok && login != "" {
+			req.SetBasicAuth(login, password)
+		}
+	}
+}
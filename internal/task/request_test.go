@@ -0,0 +1,151 @@
+package task
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    http.Header
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "  ",
+			want:  http.Header{},
+		},
+		{
+			name:  "semicolons",
+			input: "X-Api-Key: abc; Accept: */*",
+			want:  http.Header{"X-Api-Key": {"abc"}, "Accept": {"*/*"}},
+		},
+		{
+			name:  "new lines",
+			input: "X-Api-Key: abc\nAccept: */*\n",
+			want:  http.Header{"X-Api-Key": {"abc"}, "Accept": {"*/*"}},
+		},
+		{
+			name:  "cookie with several values",
+			input: "Cookie: a=1; b=2",
+			want:  http.Header{"Cookie": {"a=1; b=2"}},
+		},
+		{
+			name:  "quality values",
+			input: "Accept: text/html;q=0.9, */*;q=0.8; Referer: https://example.com/",
+			want:  http.Header{"Accept": {"text/html;q=0.9, */*;q=0.8"}, "Referer": {"https://example.com/"}},
+		},
+		{
+			name:  "repeated header",
+			input: "X-Tag: a; X-Tag: b",
+			want:  http.Header{"X-Tag": {"a", "b"}},
+		},
+		{
+			name:  "names are canonicalized",
+			input: "x-api-key: abc",
+			want:  http.Header{"X-Api-Key": {"abc"}},
+		},
+		{
+			name:    "missing colon",
+			input:   "X-Api-Key abc",
+			wantErr: true,
+		},
+		{
+			name:    "space in name",
+			input:   "X Api: abc",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHeaders(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHeaders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHeaders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCookieFile(t *testing.T) {
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	lines := []string{
+		"# Netscape HTTP Cookie File",
+		"",
+		"example.com\tFALSE\t/\tFALSE\t0\tsession\tone",
+		".example.com\tTRUE\t/\tFALSE\t" + future + "\tshared\ttwo",
+		"#HttpOnly_example.com\tFALSE\t/\tFALSE\t" + future + "\thttponly\tthree",
+		"example.com\tFALSE\t/\tTRUE\t" + future + "\tsecure\tfour",
+		"example.com\tFALSE\t/private\tFALSE\t" + future + "\tscoped\tfive",
+		"example.com\tFALSE\t/\tFALSE\t" + past + "\texpired\tsix",
+	}
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := LoadCookieFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"http://example.com/", []string{"httponly", "session", "shared"}},
+		{"https://example.com/", []string{"httponly", "secure", "session", "shared"}},
+		{"http://example.com/private/file", []string{"httponly", "scoped", "session", "shared"}},
+		{"http://cdn.example.com/", []string{"shared"}},
+		{"http://other.org/", nil},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		var got []string
+		for _, c := range jar.Cookies(u) {
+			got = append(got, c.Name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cookies for %s = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCookieFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"too few fields", "example.com\tFALSE\t/\tFALSE\t0\tname", ":1: expected 7 tab separated fields"},
+		{"bad expiry", "# comment\nexample.com\tFALSE\t/\tFALSE\tsoon\tname\tvalue", ":2: invalid expiry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cookies.txt")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadCookieFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadCookieFile() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := LoadCookieFile(filepath.Join(t.TempDir(), "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("LoadCookieFile(missing) error = %v", err)
+	}
+}
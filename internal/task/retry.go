@@ -0,0 +1,121 @@
+package task
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	baseRetryDelay = time.Second
+	maxRetryDelay  = time.Minute
+)
+
+// retryable reports whether err may go away by trying again. Timeouts,
+// connection resets, cut-off bodies and 5xx/429 responses are; missing files,
+// refused access, bad certificates and disk errors are not.
+func retryable(err error) bool {
+	var httpErr *HTTPError
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Temporary()
+	case errors.As(err, &dnsErr):
+		return !dnsErr.IsNotFound
+	case errors.As(err, &certErr), errors.As(err, &pathErr):
+		return false
+	case errors.Is(err, errRemoteChanged), errors.Is(err, errRangesIgnored), errors.Is(err, context.Canceled):
+		return false
+	}
+	return true
+}
+
+// retryDelay is how long to wait before attempt try+1. It honours Retry-After
+// and otherwise backs off exponentially with jitter, so connections that failed
+// together do not all come back at the same moment.
+func retryDelay(err error, try uint8) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return httpErr.RetryAfter
+	}
+
+	delay := maxRetryDelay
+	if try < 6 {
+		delay = min(baseRetryDelay<<try, maxRetryDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetchWithRetry keeps fetching seg from where it left off until it is done,
+// a fatal error occurs or the retries run out. An attempt that made progress
+// resets the count, so a long download survives occasional drops.
+func (t *Task) fetchWithRetry(ctx context.Context, seg *segment, file *os.File, validator string, thread int) error {
+	try := uint8(0)
+	for {
+		before := t.segmentDownloaded(seg)
+		err := t.fetch(ctx, seg, file, validator)
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		if t.segmentDownloaded(seg) > before {
+			try = 0
+		}
+		if try >= t.retries {
+			return err
+		}
+
+		delay := retryDelay(err, try)
+		try++
+		slog.Warn(fmt.Sprintf("task %s | thread %d | retry %d in %v: %v", t.filePath, thread, try, delay.Round(time.Millisecond), err))
+		t.emit(SegmentRetry{taskEvent{t}, thread, try, delay, err})
+		t.mutex.Lock()
+		t.recordError(err)
+		t.mutex.Unlock()
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Task) segmentDownloaded(seg *segment) uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return seg.downloaded
+}
@@ -0,0 +1,194 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+const (
+	// minStealSize is the smallest piece a worker will take over from another.
+	minStealSize = 256 * 1024
+
+	readBufferSize = 32 * 1024
+)
+
+// segment is a byte range of the file. Once a download runs, its fields are
+// guarded by the task mutex since idle workers may shrink a busy segment.
+type segment struct {
+	start      int64
+	end        int64 // inclusive, -1 if the size is unknown
+	downloaded uint64
+
+	active bool // a worker is fetching it
+	done   bool
+
+	rate rateMeter
+}
+
+func (s *segment) remaining() int64 {
+	return s.end - (s.start + int64(s.downloaded)) + 1
+}
+
+// splitSegments divides the file into one range per thread. A file of unknown
+// size becomes a single open-ended segment, marked by end == -1.
+func splitSegments(size int64, threads uint8) []*segment {
+	if size <= 0 {
+		return []*segment{{start: 0, end: -1}}
+	}
+	if int64(threads) > size {
+		threads = uint8(size)
+	}
+	chunkSize := size / int64(threads)
+	segments := make([]*segment, threads)
+	for i := range segments {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if i == int(threads)-1 {
+			end = size - 1
+		}
+		segments[i] = &segment{start: start, end: end}
+	}
+	return segments
+}
+
+// nextSegment hands an idle worker something to do: a segment nobody is
+// working on, or else the back half of the largest segment still in progress.
+func (t *Task) nextSegment() *segment {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, seg := range t.segments {
+		if !seg.active && !seg.done {
+			seg.active = true
+			return seg
+		}
+	}
+	if !t.resumable {
+		return nil
+	}
+
+	victim := -1
+	most := int64(0)
+	for i, seg := range t.segments {
+		if seg.active && seg.end >= 0 && seg.remaining() > most {
+			victim, most = i, seg.remaining()
+		}
+	}
+	if victim < 0 || most < 2*minStealSize {
+		return nil
+	}
+
+	seg := t.segments[victim]
+	stolen := &segment{start: seg.end - most/2 + 1, end: seg.end, active: true}
+	seg.end = stolen.start - 1
+	t.segments = append(t.segments[:victim+1], append([]*segment{stolen}, t.segments[victim+1:]...)...)
+	slog.Debug(fmt.Sprintf("task %s | split part %d-%d off a slow connection", t.filePath, stolen.start, stolen.end))
+	return stolen
+}
+
+// fetch downloads seg until it is complete. Another worker may move the end of
+// seg closer while this runs, so it is re-read after every read from the body.
+func (t *Task) fetch(ctx context.Context, seg *segment, file *os.File, validator string) error {
+	t.mutex.Lock()
+	restart := !t.resumable && seg.downloaded > 0
+	if restart {
+		// The server sends the whole file again, so start over with it
+		seg.downloaded = 0
+	}
+	start := seg.start + int64(seg.downloaded)
+	end := seg.end
+	t.mutex.Unlock()
+	if restart {
+		// A shorter body this time must not leave the old tail behind
+		if err := file.Truncate(start); err != nil {
+			return err
+		}
+	}
+	slog.Debug(fmt.Sprintf("task %s | downloading part %d-%d...", t.filePath, start, end))
+
+	req, err := t.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return err
+	}
+	// An open-ended segment starting at zero is just the whole file
+	ranged := t.resumable && (start > 0 || end >= 0)
+	if ranged {
+		if end < 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		}
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if ranged && resp.StatusCode == http.StatusOK {
+		// Either If-Range did not match and the server sent the whole
+		// new file, or it does not honour ranges at all
+		if validator != "" && !sameValidator(resp, validator) {
+			return errRemoteChanged
+		}
+		return errRangesIgnored
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return newHTTPError(resp)
+	}
+
+	buffer := make([]byte, readBufferSize)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			t.mutex.Lock()
+			end = seg.end
+			t.mutex.Unlock()
+			if end >= 0 && start+int64(n) > end+1 {
+				n = int(end + 1 - start)
+			}
+
+			// Only the bytes kept count against the speed limit
+			if err := t.limiter.WaitN(ctx, n); err != nil {
+				return err
+			}
+
+			if _, err := file.WriteAt(buffer[:n], start); err != nil {
+				return err
+			}
+			t.mutex.Lock()
+			seg.downloaded += uint64(n)
+			t.mutex.Unlock()
+			start += int64(n)
+
+			if end >= 0 && start > end {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			if end >= 0 {
+				return io.ErrUnexpectedEOF
+			}
+			t.mutex.Lock()
+			seg.end = start - 1
+			t.mutex.Unlock()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sameValidator reports whether resp describes the same file version as the
+// If-Range validator that was sent.
+func sameValidator(resp *http.Response, validator string) bool {
+	return resp.Header.Get("ETag") == validator || resp.Header.Get("Last-Modified") == validator
+}
@@ -0,0 +1,204 @@
+package task
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchRetryWithoutRanges(t *testing.T) {
+	data := make([]byte, 256<<10)
+	rand.Read(data)
+
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "none")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		if gets.Add(1) == 1 {
+			// Cut the first transfer off halfway through
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	task := NewTask(srv.URL+"/file.bin", t.TempDir(), 4, 3, nil)
+	task.Resume()
+	waitDone(t, task)
+
+	if status := task.Status(); status != Completed {
+		t.Fatalf("status = %v, want Completed (last error: %v)", status, task.LastError())
+	}
+	if gets.Load() < 2 {
+		t.Fatalf("server saw %d GET requests, want a retry", gets.Load())
+	}
+	got, err := os.ReadFile(task.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match the original")
+	}
+}
+
+func TestFetchRetryStreamed(t *testing.T) {
+	// An archive built on the fly: no length, no ranges, and a different
+	// body every time
+	first := make([]byte, 5000)
+	rand.Read(first)
+	data := make([]byte, 3000)
+	rand.Read(data)
+
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "none")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if gets.Add(1) == 1 {
+			w.Write(first)
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	task := NewTask(srv.URL+"/file.tar", t.TempDir(), 1, 3, nil)
+	task.Resume()
+	waitDone(t, task)
+
+	if status := task.Status(); status != Completed {
+		t.Fatalf("status = %v, want Completed (last error: %v)", status, task.LastError())
+	}
+	if got := task.TotalSize(); got != int64(len(data)) {
+		t.Errorf("TotalSize() = %d, want %d", got, len(data))
+	}
+	got, err := os.ReadFile(task.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, want the %d of the last transfer", len(got), len(data))
+	}
+}
+
+func TestFetchRemoteChanged(t *testing.T) {
+	old := make([]byte, 256<<10)
+	rand.Read(old)
+	data := make([]byte, 300<<10)
+	rand.Read(data)
+
+	// The server now has a new version, so If-Range "v1" gets the whole of it
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "file.bin")
+	if err := os.WriteFile(filePath+".part", old[:100<<10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	task := Restore(State{
+		ID:            newID(),
+		URL:           srv.URL + "/file.bin",
+		DirectoryPath: dir,
+		FilePath:      filePath,
+		FileSize:      int64(len(old)),
+		Threads:       2,
+		Retries:       1,
+		Segments: []SegmentState{
+			{Start: 0, End: 128<<10 - 1, Downloaded: 50 << 10},
+			{Start: 128 << 10, End: 256<<10 - 1, Downloaded: 50 << 10},
+		},
+		ETag:      `"v1"`,
+		Resumable: true,
+		Status:    Paused,
+	}, nil)
+	task.Resume()
+	waitDone(t, task)
+
+	if status := task.Status(); status != Completed {
+		t.Fatalf("status = %v, want Completed (last error: %v)", status, task.LastError())
+	}
+	if got := task.Notice(); got != "remote file changed, restarted" {
+		t.Errorf("notice = %q", got)
+	}
+	got, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file is not the new version")
+	}
+}
+
+func TestFetchRangesIgnored(t *testing.T) {
+	data := make([]byte, 256<<10)
+	rand.Read(data)
+
+	// Claims range support, then answers every request with the whole file
+	var ranged atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Header.Get("Range") != "" {
+			ranged.Add(1)
+		}
+		if r.Method != http.MethodHead {
+			w.Write(data)
+		}
+	}))
+	defer srv.Close()
+
+	task := NewTask(srv.URL+"/file.bin", t.TempDir(), 4, 1, nil)
+	task.Resume()
+	waitDone(t, task)
+
+	if status := task.Status(); status != Completed {
+		t.Fatalf("status = %v, want Completed (last error: %v)", status, task.LastError())
+	}
+	if ranged.Load() == 0 {
+		t.Fatal("task never asked for a range")
+	}
+	if got := task.Notice(); got != noRangesNotice {
+		t.Errorf("notice = %q, want %q", got, noRangesNotice)
+	}
+	if task.State().Resumable {
+		t.Error("task still counts as resumable")
+	}
+	got, err := os.ReadFile(task.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match the original")
+	}
+}
+
+// waitDone waits until task stops running and its workers have exited.
+func waitDone(t *testing.T, task *Task) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for task.Status() == InProgress {
+		if time.Now().After(deadline) {
+			t.Fatal("task did not finish in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	task.wait()
+}
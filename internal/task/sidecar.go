@@ -0,0 +1,129 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sharif-go-lab/go-download-manager/internal/utils"
+)
+
+// maxSidecarSize caps how much of a checksum file is read.
+const maxSidecarSize = 1 << 20
+
+var (
+	// sidecarExts are tried as <name>.<ext>, strongest first.
+	sidecarExts = []string{"sha512", "sha256", "sha1", "md5"}
+	// sumsFiles are the per-directory lists published by most release pages.
+	sumsFiles = []struct{ name, algo string }{
+		{"SHA512SUMS", "sha512"},
+		{"SHA256SUMS", "sha256"},
+		{"SHA1SUMS", "sha1"},
+		{"MD5SUMS", "md5"},
+	}
+
+	// bsdSumLine matches "SHA256 (file.iso) = 9f86d0...".
+	bsdSumLine = regexp.MustCompile(`^([A-Za-z0-9-]+) ?\((.+)\) ?= ?([0-9a-fA-F]+)$`)
+)
+
+// discoverChecksum looks for a checksum published next to the file, first as
+// <name>.<algo> and then in the usual *SUMS files of the same directory.
+func (t *Task) discoverChecksum(ctx context.Context) *Checksum {
+	base, err := url.Parse(t.url)
+	if err != nil {
+		return nil
+	}
+	names := []string{path.Base(base.Path), filepath.Base(t.filePath)}
+
+	for _, ext := range sidecarExts {
+		ref := &url.URL{Path: names[0] + "." + ext}
+		if c := t.sidecarChecksum(ctx, base.ResolveReference(ref), names, ext, true); c != nil {
+			return c
+		}
+	}
+	for _, sums := range sumsFiles {
+		ref := &url.URL{Path: sums.name}
+		if c := t.sidecarChecksum(ctx, base.ResolveReference(ref), names, sums.algo, false); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// sidecarChecksum downloads a checksum file and returns the entry for one of
+// names. A file made for a single artifact may hold just the bare digest.
+func (t *Task) sidecarChecksum(ctx context.Context, u *url.URL, names []string, algo string, bare bool) *Checksum {
+	req, err := t.newRequestTo(ctx, http.MethodGet, u.String())
+	if err != nil {
+		return nil
+	}
+	resp, err := t.do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSidecarSize))
+	if err != nil {
+		return nil
+	}
+
+	c, err := parseSums(body, names, algo, bare)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("task %s | ignoring %s: %v", t.filePath, utils.RedactURL(u.String()), err))
+		return nil
+	}
+	if c != nil {
+		slog.Info(fmt.Sprintf("task %s | using checksum from %s", t.filePath, utils.RedactURL(u.String())))
+	}
+	return c
+}
+
+// parseSums finds the digest of one of names in the output of sha256sum and
+// friends, in either the GNU ("<hex>  <name>", "<hex> *<name>") or the BSD
+// ("SHA256 (<name>) = <hex>") format.
+func parseSums(data []byte, names []string, algo string, bare bool) (*Checksum, error) {
+	matches := func(name string) bool {
+		name = strings.TrimPrefix(name, "./")
+		for _, n := range names {
+			if name == n || path.Base(name) == n {
+				return true
+			}
+		}
+		return false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := bsdSumLine.FindStringSubmatch(line); m != nil {
+			if matches(m[2]) {
+				return newChecksum(m[1], m[3], hex.DecodeString)
+			}
+			continue
+		}
+
+		sum, name, found := strings.Cut(line, " ")
+		name = strings.TrimLeft(name, " *")
+		if (found && matches(name)) || (!found && bare) {
+			return newChecksum(algo, sum, hex.DecodeString)
+		}
+	}
+	return nil, scanner.Err()
+}
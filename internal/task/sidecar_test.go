@@ -0,0 +1,94 @@
+package task
+
+import "testing"
+
+func TestParseSums(t *testing.T) {
+	const (
+		sha256Test  = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" // "test"
+		sha256Other = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" // "foo"
+		md5Test     = "098f6bcd4621d373cade4e832627b4f6"
+	)
+	tests := []struct {
+		name    string
+		data    string
+		algo    string
+		bare    bool
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "gnu text mode",
+			data: sha256Other + "  other.iso\n" + sha256Test + "  file.iso\n",
+			algo: "sha256",
+			want: "sha256:" + sha256Test,
+		},
+		{
+			name: "gnu binary mode",
+			data: sha256Other + " *other.iso\n" + sha256Test + " *file.iso\n",
+			algo: "sha256",
+			want: "sha256:" + sha256Test,
+		},
+		{
+			name: "relative paths",
+			data: sha256Other + "  ./other.iso\n" + sha256Test + "  ./release/file.iso\n",
+			algo: "sha256",
+			want: "sha256:" + sha256Test,
+		},
+		{
+			name: "comments and blank lines",
+			data: "# SHA256 sums\n\n" + sha256Test + "  file.iso\n",
+			algo: "sha256",
+			want: "sha256:" + sha256Test,
+		},
+		{
+			name: "bsd format",
+			data: "SHA256 (other.iso) = " + sha256Other + "\nSHA256 (file.iso) = " + sha256Test + "\n",
+			algo: "sha256",
+			want: "sha256:" + sha256Test,
+		},
+		{
+			name: "bsd format names its algorithm",
+			data: "MD5 (file.iso) = " + md5Test + "\n",
+			algo: "sha256",
+			want: "md5:" + md5Test,
+		},
+		{
+			name: "bare digest",
+			data: sha256Test + "\n",
+			algo: "sha256",
+			bare: true,
+			want: "sha256:" + sha256Test,
+		},
+		{
+			name: "bare digest in a shared file",
+			data: sha256Test + "\n",
+			algo: "sha256",
+		},
+		{
+			name: "no entry for the file",
+			data: sha256Other + "  other.iso\n",
+			algo: "sha256",
+		},
+		{
+			name:    "digest of the wrong length",
+			data:    md5Test + "  file.iso\n",
+			algo:    "sha256",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseSums([]byte(tt.data), []string{"file.iso"}, tt.algo, tt.bare)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSums() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got string
+			if c != nil {
+				got = c.String()
+			}
+			if got != tt.want {
+				t.Errorf("parseSums() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
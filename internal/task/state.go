@@ -0,0 +1,131 @@
+package task
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const stateExt = ".json"
+
+// SegmentState is the persisted form of a single byte range of a task.
+type SegmentState struct {
+	Start      int64  `json:"start"`
+	End        int64  `json:"end"`
+	Downloaded uint64 `json:"downloaded"`
+}
+
+// ErrorState is the persisted form of an entry of the error history.
+type ErrorState struct {
+	Time    time.Time `json:"time"`
+	Kind    ErrorKind `json:"kind"`
+	Message string    `json:"message"`
+}
+
+// State is everything needed to rebuild a Task after a restart.
+type State struct {
+	ID             string         `json:"id"`
+	URL            string         `json:"url"`
+	Queue          string         `json:"queue"`
+	DirectoryPath  string         `json:"directory_path"`
+	FilePath       string         `json:"file_path"`
+	FileSize       int64          `json:"file_size"`
+	Threads        uint8          `json:"threads"`
+	Retries        uint8          `json:"retries"`
+	Segments       []SegmentState `json:"segments"`
+	ETag           string         `json:"etag,omitempty"`
+	LastModified   string         `json:"last_modified,omitempty"`
+	Resumable      bool           `json:"resumable"`
+	Checksum       string         `json:"checksum,omitempty"`
+	AutoChecksum   bool           `json:"auto_checksum,omitempty"`
+	RemoteChecksum bool           `json:"remote_checksum,omitempty"`
+	Status         DownloadStatus `json:"status"`
+	Errors         []ErrorState   `json:"errors,omitempty"`
+	SpeedLimit     uint64         `json:"speed_limit,omitempty"` // KB/s
+	StartAt        *time.Time     `json:"start_at,omitempty"`
+
+	Request *RequestOptions `json:"request,omitempty"`
+}
+
+// DefaultStateDir returns the directory where task states are kept.
+func DefaultStateDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-download-manager", "tasks"), nil
+}
+
+func newID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+// SaveState atomically writes s to <dir>/<id>.json. States may hold
+// credentials, so only the user can read them.
+func SaveState(dir string, s State) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+
+	path := filepath.Join(dir, s.ID+stateExt)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// RemoveState deletes the state file of the task with the given id.
+func RemoveState(dir, id string) error {
+	err := os.Remove(filepath.Join(dir, id+stateExt))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// LoadStates reads every state file in dir, oldest task first. A file that
+// cannot be read or decoded is logged and skipped, so it costs only its task.
+func LoadStates(dir string) ([]State, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	states := make([]State, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), stateExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			slog.Error(fmt.Sprintf("read state %s: %v", entry.Name(), err))
+			continue
+		}
+		var s State
+		if err := json.Unmarshal(data, &s); err != nil {
+			slog.Error(fmt.Sprintf("decode state %s: %v", entry.Name(), err))
+			continue
+		}
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ID < states[j].ID
+	})
+	return states, nil
+}
@@ -0,0 +1,123 @@
+package task
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "tasks")
+	filePath := filepath.Join(dir, "file.bin")
+	if err := os.WriteFile(filePath+".part", make([]byte, 300), 0644); err != nil {
+		t.Fatal(err)
+	}
+	startAt := time.Date(2026, 6, 1, 23, 30, 0, 0, time.UTC)
+
+	want := State{
+		ID:            newID(),
+		URL:           "https://example.com/file.bin",
+		Queue:         "Videos",
+		DirectoryPath: dir,
+		FilePath:      filePath,
+		FileSize:      1000,
+		Threads:       2,
+		Retries:       3,
+		Segments: []SegmentState{
+			{Start: 0, End: 499, Downloaded: 200},
+			{Start: 500, End: 999, Downloaded: 100},
+		},
+		ETag:           `"v1"`,
+		LastModified:   "Mon, 01 Jun 2026 10:00:00 GMT",
+		Resumable:      true,
+		Checksum:       "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		RemoteChecksum: true,
+		Status:         Paused,
+		Errors: []ErrorState{
+			{Time: time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC), Kind: KindNetwork, Message: "connection reset"},
+		},
+		SpeedLimit: 200,
+		StartAt:    &startAt,
+		Request: &RequestOptions{
+			Header:   http.Header{"X-Api-Key": {"abc"}},
+			Username: "user",
+			Password: "secret",
+		},
+	}
+	if err := SaveState(stateDir, want); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(stateDir, want.ID+stateExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("state file mode = %o, want 600", perm)
+	}
+
+	// A broken file must not keep the others from loading
+	if err := os.WriteFile(filepath.Join(stateDir, "broken.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	states, err := LoadStates(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 {
+		t.Fatalf("loaded %d states, want 1", len(states))
+	}
+	if !reflect.DeepEqual(states[0], want) {
+		t.Fatalf("loaded state\n%+v\nwant\n%+v", states[0], want)
+	}
+
+	restored := Restore(states[0], nil)
+	if got := restored.State(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored state\n%+v\nwant\n%+v", got, want)
+	}
+	if got := restored.Downloaded(); got != 300 {
+		t.Errorf("Downloaded() = %d, want 300", got)
+	}
+	if got := restored.Status(); got != Paused {
+		t.Errorf("Status() = %v, want Paused", got)
+	}
+}
+
+func TestRestoreWithoutPartFile(t *testing.T) {
+	dir := t.TempDir()
+	s := State{
+		ID:        newID(),
+		URL:       "https://example.com/file.bin",
+		FilePath:  filepath.Join(dir, "file.bin"),
+		FileSize:  1000,
+		Threads:   1,
+		Segments:  []SegmentState{{Start: 0, End: 999, Downloaded: 400}},
+		Resumable: true,
+		Status:    InProgress,
+	}
+
+	restored := Restore(s, nil)
+	if got := restored.Downloaded(); got != 0 {
+		t.Errorf("Downloaded() = %d, want 0 once the part file is gone", got)
+	}
+	if got := restored.Status(); got != Pending {
+		t.Errorf("Status() = %v, want Pending", got)
+	}
+
+	s.Status = Completed
+	s.Segments[0].Downloaded = 1000
+	restored = Restore(s, nil)
+	if got := restored.Downloaded(); got != 1000 {
+		t.Errorf("completed Downloaded() = %d, want 1000", got)
+	}
+}
+
+func TestLoadStatesMissingDir(t *testing.T) {
+	states, err := LoadStates(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || states != nil {
+		t.Fatalf("LoadStates() = %v, %v, want nil, nil", states, err)
+	}
+}
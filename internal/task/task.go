@@ -2,14 +2,16 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/sharif-go-lab/go-download-manager/internal/utils"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,87 +23,384 @@ const (
 	Completed
 	Canceled
 	Failed
+	VerificationFailed // downloaded, but the checksum did not match
+)
+
+// maxRestarts bounds how often a task starts over because the remote file changed.
+const maxRestarts = 3
+
+const noRangesNotice = "server does not support ranges, pausing restarts from zero"
+
+var (
+	errRemoteChanged = errors.New("remote file changed")
+	errRangesIgnored = errors.New("server ignored range request")
+	errNotRetryable  = errors.New("only failed or canceled tasks can be retried")
 )
 
 type Task struct {
+	id            string
 	url           string
 	DirectoryPath string
 	status        DownloadStatus
 
-	fileSize int64
-	filePath string
+	fileSize     int64 // -1 while unknown
+	filePath     string
+	probed       bool
+	etag         string
+	lastModified string
 
-	threads uint8
-	downloaded []uint64
+	threads   uint8
+	segments  []*segment
+	resumable bool
 
-	mutex sync.Mutex
-	ctx context.Context
+	mutex      sync.Mutex
+	ctx        context.Context
 	cancelFunc context.CancelFunc
+	done       chan struct{} // closed when the last run started exits
+	rate       rateMeter
 
 	retries uint8
-	limiter <-chan time.Time
+	limiter *utils.Limiter // the task's own, under its queue's
+	client  *http.Client
+
+	request      RequestOptions
+	jar          http.CookieJar
+	queueRequest RequestOptions
+	queueJar     http.CookieJar
+	netrc        *utils.Netrc
+
+	queue    string
+	startAt  time.Time // not started before, if set
+	stateDir string
+
+	// saveMutex orders writes and the removal of the state file, so a save
+	// that raced a Cancel cannot bring the task back
+	saveMutex sync.Mutex
+	notice   string
+	lastErr  error // a TaskError once the task failed
+	errors   []TaskError
+
+	events      *Bus
+	queueEvents atomic.Pointer[Bus] // the queue's bus, if attached; read without t.mutex
+
+	checksum       *Checksum
+	remoteChecksum bool // checksum came from the server, not the user
+	autoChecksum   bool // look for SHA256SUMS and the like if checksum is nil
+
+	preallocate     bool
+	pauseOnLowSpace bool
 }
 
-func NewTask(url, directoryPath string, threads, retires uint8, limiter <-chan time.Time) *Task {
+// NewTask creates a pending task whose bandwidth is bounded by limiter, the
+// queue's limiter, and by its own limit if one is set.
+func NewTask(url, directoryPath string, threads, retires uint8, limiter *utils.Limiter) *Task {
 	return &Task{
+		id:            newID(),
 		url:           url,
+		events:        NewBus(),
 		status:        Pending,
 		retries:       retires,
 		DirectoryPath: directoryPath,
 
 		fileSize: -1,
 		threads: threads,
-		limiter: limiter,
-		downloaded: make([]uint64, threads),
+		limiter: utils.NewChildLimiter(limiter, 0),
 	}
 }
-func (t *Task)setDirectory(directory string)  {
-	t.DirectoryPath = directory
+
+// Restore rebuilds a task from its persisted state. Tasks that were running
+// when the state was written come back as Pending so their queue restarts them.
+func Restore(s State, limiter *utils.Limiter) *Task {
+	t := &Task{
+		id:            s.ID,
+		url:           s.URL,
+		events:        NewBus(),
+		status:        s.Status,
+		retries:       s.Retries,
+		DirectoryPath: s.DirectoryPath,
+
+		fileSize:     s.FileSize,
+		filePath:     s.FilePath,
+		probed:       s.Segments != nil,
+		etag:         s.ETag,
+		lastModified: s.LastModified,
+		resumable:    s.Resumable,
+
+		threads: s.Threads,
+		limiter: utils.NewChildLimiter(limiter, s.SpeedLimit*1024),
+		queue:   s.Queue,
+
+		autoChecksum:   s.AutoChecksum,
+		remoteChecksum: s.RemoteChecksum,
+	}
+	if s.StartAt != nil {
+		t.startAt = *s.StartAt
+	}
+	if s.Checksum != "" {
+		t.checksum, _ = ParseChecksum(s.Checksum)
+	}
+	for _, e := range s.Errors {
+		t.errors = append(t.errors, TaskError{e.Time, e.Kind, errors.New(e.Message)})
+	}
+	if s.Request != nil {
+		if err := t.SetRequestOptions(*s.Request); err != nil {
+			slog.Error(fmt.Sprintf("task %s | %v", t.filePath, err))
+			t.request = *s.Request
+		}
+	}
+	if len(t.errors) > 0 && (t.status == Failed || t.status == VerificationFailed) {
+		t.lastErr = t.errors[len(t.errors)-1]
+	}
+	if t.status == InProgress {
+		t.status = Pending
+	}
+	if t.probed && !t.resumable {
+		t.notice = noRangesNotice
+	}
+
+	if t.filePath != "" && t.status != Completed && t.status != Canceled {
+		utils.ReserveFilePath(t.filePath)
+	}
+	// A finished download was renamed away from its .part file
+	_, err := os.Stat(t.partPath())
+	missing := t.status != Completed && os.IsNotExist(err)
+	for _, seg := range s.Segments {
+		restored := &segment{start: seg.Start, end: seg.End, downloaded: seg.Downloaded}
+		if missing {
+			restored.downloaded = 0
+		}
+		restored.done = restored.end >= 0 && restored.remaining() <= 0
+		t.segments = append(t.segments, restored)
+	}
+	return t
 }
-func (t *Task) start() {
+
+// State returns a snapshot of the task suitable for persisting.
+func (t *Task) State() State {
 	t.mutex.Lock()
-	if t.status == InProgress {
-		slog.Error("task already started")
-		t.mutex.Unlock()
+	defer t.mutex.Unlock()
+
+	s := State{
+		ID:             t.id,
+		URL:            t.url,
+		Queue:          t.queue,
+		DirectoryPath:  t.DirectoryPath,
+		FilePath:       t.filePath,
+		FileSize:       t.fileSize,
+		Threads:        t.threads,
+		Retries:        t.retries,
+		ETag:           t.etag,
+		LastModified:   t.lastModified,
+		Resumable:      t.resumable,
+		AutoChecksum:   t.autoChecksum,
+		RemoteChecksum: t.remoteChecksum,
+		Status:         t.status,
+		SpeedLimit:     t.limiter.Rate() / 1024,
+	}
+	if t.checksum != nil {
+		s.Checksum = t.checksum.String()
+	}
+	if !t.startAt.IsZero() {
+		startAt := t.startAt
+		s.StartAt = &startAt
+	}
+	if !t.request.Empty() {
+		request := t.request
+		s.Request = &request
+	}
+	for _, e := range t.errors {
+		s.Errors = append(s.Errors, ErrorState{Time: e.Time, Kind: e.Kind, Message: e.Err.Error()})
+	}
+	for _, seg := range t.segments {
+		s.Segments = append(s.Segments, SegmentState{Start: seg.start, End: seg.end, Downloaded: seg.downloaded})
+	}
+	return s
+}
+
+// SetPersistence makes the task save its state under dir, tagged with the
+// name of the queue it belongs to.
+func (t *Task) SetPersistence(dir, queue string) {
+	t.mutex.Lock()
+	t.stateDir = dir
+	t.queue = queue
+	t.mutex.Unlock()
+}
+
+func (t *Task) persist() {
+	t.saveMutex.Lock()
+	defer t.saveMutex.Unlock()
+
+	t.mutex.Lock()
+	dir := t.stateDir
+	t.mutex.Unlock()
+	if dir == "" {
 		return
-	} else if t.status == Completed {
-		slog.Error("task already completed")
-		t.mutex.Unlock()
+	}
+	s := t.State()
+	if s.Status == Canceled {
 		return
-	} else if t.status == Canceled {
-		slog.Error("task already canceled")
-		t.mutex.Unlock()
+	}
+	if err := SaveState(dir, s); err != nil {
+		slog.Error(fmt.Sprintf("task %s | save state failed: %v", t.filePath, err))
+	}
+}
+
+// removeState deletes the saved state, after any save in progress.
+func (t *Task) removeState() {
+	t.saveMutex.Lock()
+	defer t.saveMutex.Unlock()
+
+	t.mutex.Lock()
+	dir := t.stateDir
+	t.mutex.Unlock()
+	if dir == "" {
 		return
 	}
-	t.ctx, t.cancelFunc = context.WithCancel(context.Background())
-	t.status = InProgress
+	if err := RemoveState(dir, t.id); err != nil {
+		slog.Error(fmt.Sprintf("task %s | remove state failed: %v", t.filePath, err))
+	}
+}
+
+func (t *Task)setDirectory(directory string)  {
+	t.DirectoryPath = directory
+}
+// start runs the download. Resume sets the task InProgress and creates its
+// context before launching it, so a Pause can never slip in between. The run
+// only ever looks at that context, never at the one of a later Resume, and
+// closes done once it has exited.
+func (t *Task) start(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for restarts := 0; ; restarts++ {
+		err := t.download(ctx)
+		if err == nil {
+			return
+		}
+		if restarts == maxRestarts {
+			slog.Error(fmt.Sprintf("task %s | %v too many times, giving up", t.filePath, err))
+			t.fail(err)
+			return
+		}
+		slog.Warn(fmt.Sprintf("task %s | %v, restarting download", t.filePath, err))
+		t.mutex.Lock()
+		t.recordError(err)
+		t.mutex.Unlock()
+		t.restart(err)
+	}
+}
+
+// restart throws away everything downloaded so far, keeping the file name.
+func (t *Task) restart(reason error) {
+	t.mutex.Lock()
+	t.segments = nil
+	if errors.Is(reason, errRangesIgnored) {
+		t.resumable = false
+		t.notice = noRangesNotice
+	} else {
+		t.probed = false
+		t.fileSize = -1
+		t.etag = ""
+		t.lastModified = ""
+		if t.remoteChecksum {
+			// It described the old file, the new one advertises its own
+			t.checksum = nil
+			t.remoteChecksum = false
+		}
+		t.notice = "remote file changed, restarted"
+	}
 	t.mutex.Unlock()
 
-	if t.fileSize == -1 {
+	if err := os.Truncate(t.partPath(), 0); err != nil && !os.IsNotExist(err) {
+		slog.Error(fmt.Sprintf("task %s | truncate file failed: %v", t.filePath, err))
+	}
+}
+
+// partPath is where the file is written until it is complete.
+func (t *Task) partPath() string {
+	return t.filePath + utils.PartSuffix
+}
+
+// validator returns the value to send in If-Range. Weak ETags are not allowed
+// there, so Last-Modified is used instead.
+func (t *Task) validator() string {
+	if t.etag != "" && !strings.HasPrefix(t.etag, "W/") {
+		return t.etag
+	}
+	return t.lastModified
+}
+
+// download fetches the remaining segments. It returns errRemoteChanged or
+// errRangesIgnored when the caller has to start over from zero; any other
+// outcome is recorded in the task status.
+func (t *Task) download(ctx context.Context) error {
+	t.mutex.Lock()
+	probed := t.probed
+	t.mutex.Unlock()
+	if !probed {
 		for try := uint8(0); try <= t.retries; try++ {
-			resp, err := http.Head(t.url)
+			info, err := t.probe(ctx)
 			if err == nil {
-				t.filePath = filepath.Join(t.DirectoryPath, utils.FileName(resp))
-				t.fileSize = resp.ContentLength
-				if _, err := os.Stat(t.filePath); err == nil {
-					// If file already exists, pick a unique name:
-					newPath := utils.FindUniqueFilePath(t.filePath)
-					//	slog.Debug(fmt.Sprintf("task %s exists, using new name %s", t.filePath, newPath))
-					t.filePath = newPath
+				t.mutex.Lock()
+				t.fileSize = info.size
+				t.etag = info.etag
+				t.lastModified = info.lastModified
+				t.resumable = info.ranges
+				t.probed = true
+				if t.checksum == nil && info.checksum != nil {
+					slog.Debug(fmt.Sprintf("task %s | server advertises %s", t.filePath, info.checksum))
+					t.checksum = info.checksum
+					t.remoteChecksum = true
 				}
+				if !t.resumable {
+					t.notice = noRangesNotice
+				}
+				if t.filePath == "" {
+					// Pick a unique name, reserved so no other task takes it
+					t.filePath = utils.FindUniqueFilePath(filepath.Join(t.DirectoryPath, utils.FileName(info.resp)))
+				}
+				t.mutex.Unlock()
 				slog.Debug(fmt.Sprintf("task %s | retry %d | file size: %d", t.filePath, try, t.fileSize))
 				break
 			}
 
-			if try == t.retries {
-				slog.Error(fmt.Sprintf("task %s | retry %d | head url %s failed: %v", t.filePath, try, t.url, err))
-				t.status = Failed
-				return
+			if ctx.Err() != nil {
+				return nil
 			}
-			time.Sleep(time.Second * (1 << try))
+			if try == t.retries || !retryable(err) {
+				slog.Error(fmt.Sprintf("task %s | retry %d | probe url %s failed: %v", t.filePath, try, utils.RedactURL(t.url), err))
+				t.fail(err)
+				return nil
+			}
+			t.mutex.Lock()
+			t.recordError(err)
+			t.mutex.Unlock()
+			if sleep(ctx, retryDelay(err, try)) != nil {
+				return nil
+			}
+		}
+	}
+	t.mutex.Lock()
+	truncate := false
+	if t.segments == nil {
+		threads := t.threads
+		if !t.resumable || t.fileSize < 0 {
+			threads = 1
+		}
+		t.segments = splitSegments(t.fileSize, threads)
+	} else if !t.resumable {
+		// Without range support the only way to continue is from the start
+		for _, seg := range t.segments {
+			truncate = truncate || seg.downloaded > 0
+			seg.downloaded = 0
+			seg.done = false
+		}
+	}
+	t.mutex.Unlock()
+	if truncate {
+		if err := os.Truncate(t.partPath(), 0); err != nil && !os.IsNotExist(err) {
+			slog.Error(fmt.Sprintf("task %s | truncate file failed: %v", t.filePath, err))
 		}
 	}
-	chunkSize := t.fileSize / int64(t.threads)
 
 	//if t.status != Paused {
 	//if _, err := os.Stat(t.filePath); err == nil {
@@ -112,175 +411,501 @@ func (t *Task) start() {
 	//}
 	//}
 
-	file, err := os.OpenFile(t.filePath, os.O_CREATE|os.O_RDWR, 0666)
+	if err := t.checkSpace(); err != nil {
+		slog.Error(fmt.Sprintf("task %s | %v", t.filePath, err))
+		if !t.pauseForSpace(err) {
+			t.fail(err)
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(t.partPath(), os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		slog.Error(fmt.Sprintf("task %s | open file failed: %v", t.filePath, err))
-		t.status = Failed
-		return
+		t.fail(err)
+		return nil
 	}
 	defer file.Close()
+	if err := t.allocate(file); err != nil {
+		slog.Error(fmt.Sprintf("task %s | preallocate file failed: %v", t.filePath, err))
+		if !t.pauseForSpace(err) {
+			t.fail(err)
+		}
+		return nil
+	}
 
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	validator := t.validator()
+	var restart, failure atomic.Value
+
+	workers := int(t.threads)
+	if !t.resumable || t.fileSize < 0 {
+		workers = 1
+	}
 	var wg sync.WaitGroup
-	done := make([]bool, t.threads)
-	for i := 0; i < int(t.threads); i++ {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
 
-			for try := uint8(0); try <= t.retries; try++ {
-				start := int64(i) * chunkSize
-				end := start + chunkSize - 1
-				if i == int(t.threads)-1 {
-					end = t.fileSize
-				}
-
-				start += int64(t.downloaded[i])
-				if start > end {
-					break
-				}
-				slog.Debug(fmt.Sprintf("task %s | thread %d | retry %d | downloading part %d-%d...", t.filePath, i+1, try, start, end))
-
-				req, err := http.NewRequest("GET", t.url, nil)
-				if err != nil {
-					slog.Error(fmt.Sprintf("task %s | thread %d | retry %d | create request failed: %v", t.filePath, i+1, try, err))
-					return
+			for seg := t.nextSegment(); seg != nil; seg = t.nextSegment() {
+				err := t.fetchWithRetry(workCtx, seg, file, validator, i+1)
+				t.mutex.Lock()
+				seg.active = false
+				seg.done = err == nil
+				t.mutex.Unlock()
+				if err == nil {
+					continue
 				}
-				req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
 
-				resp, err := http.DefaultClient.Do(req)
-				if err != nil {
-					slog.Error(fmt.Sprintf("task %s | thread %d | retry %d | send request failed: %v", t.filePath, i+1, try, err))
-					return
-				}
-				defer resp.Body.Close()
-
-				buffer := make([]byte, 1024)
-				for {
-					select {
-					case <-t.ctx.Done():
-						slog.Debug(fmt.Sprintf("task %s | thread %d | retry %d | download cancelled", t.filePath, i+1, try))
-						return
-					default:
-						n, err := resp.Body.Read(buffer)
-						if n > 0 {
-							if t.limiter != nil {
-								<-t.limiter
-							}
-
-							if _, err := file.WriteAt(buffer[:n], start); err != nil {
-								return
-							}
-							t.downloaded[i] += uint64(n)
-
-							start += int64(n)
-						}
-						if err == io.EOF {
-							slog.Debug(fmt.Sprintf("task %s | thread %d | retry %d | download finished!", t.filePath, i+1, try))
-							done[i] = true
-							return
-						}
-						if err != nil {
-							return
-						}
-					}
+				if workCtx.Err() != nil {
+					slog.Debug(fmt.Sprintf("task %s | thread %d | download cancelled", t.filePath, i+1))
+				} else if errors.Is(err, errRemoteChanged) || errors.Is(err, errRangesIgnored) {
+					slog.Warn(fmt.Sprintf("task %s | thread %d | %v", t.filePath, i+1, err))
+					restart.CompareAndSwap(nil, err)
+					cancel()
+				} else if diskFull(err) {
+					// No other worker can do better, stop them all
+					slog.Error(fmt.Sprintf("task %s | thread %d | download failed: %v", t.filePath, i+1, err))
+					failure.CompareAndSwap(nil, err)
+					cancel()
+				} else {
+					// Another worker picks the segment up again if it can
+					slog.Error(fmt.Sprintf("task %s | thread %d | download failed: %v", t.filePath, i+1, err))
+					failure.CompareAndSwap(nil, err)
 				}
+				return
 			}
 		}(i)
 	}
 
-	ch := make(chan struct{})
-	go func(ch chan struct{}) {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
 		for {
 			select {
-			case <-ch:
+			case <-stop:
 				return
-			default:
-				slog.Info(fmt.Sprintf("task %s | downloading %.2f%%...", t.filePath, float64(t.downloaded[0])/float64(t.fileSize)*100))
-				time.Sleep(time.Second)
+			case <-ticker.C:
+				t.emitProgress()
+				t.persist()
 			}
 		}
-	}(ch)
+	}()
 	wg.Wait()
-	ch <- struct{}{}
+	close(stop)
+	t.emitProgress()
 
-	for i := 0; i < int(t.threads); i++ {
-		if !done[i] {
-			slog.Error(fmt.Sprintf("task %s | thread %d failed", t.filePath, i+1))
-			t.mutex.Lock()
-			if t.status == InProgress {
-				t.status = Failed
-			}
+	if err, ok := restart.Load().(error); ok && ctx.Err() == nil {
+		return err
+	}
+
+	defer t.persist()
+	if ctx.Err() != nil {
+		return nil
+	}
+	t.mutex.Lock()
+	for _, seg := range t.segments {
+		if !seg.done {
 			t.mutex.Unlock()
-			return
+			err, ok := failure.Load().(error)
+			if !ok {
+				err = errors.New("download incomplete")
+			}
+			slog.Error(fmt.Sprintf("task %s | download failed: %v", t.filePath, err))
+			if !t.pauseForSpace(err) {
+				t.fail(err)
+			}
+			return nil
+		}
+	}
+	streamed := t.fileSize < 0
+	if streamed {
+		// Streamed downloads only learn their size at the end
+		t.fileSize = int64(t.segments[0].downloaded)
+	}
+	size := t.fileSize
+	t.mutex.Unlock()
+
+	if streamed {
+		if err := file.Truncate(size); err != nil {
+			slog.Error(fmt.Sprintf("task %s | truncate file failed: %v", t.filePath, err))
+			t.fail(err)
+			return nil
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		slog.Error(fmt.Sprintf("task %s | sync file failed: %v", t.filePath, err))
+		t.fail(err)
+		return nil
+	}
+	t.mutex.Lock()
+	checksum, discover := t.checksum, t.checksum == nil && t.autoChecksum
+	t.mutex.Unlock()
+	if discover {
+		checksum = t.discoverChecksum(ctx)
+		t.mutex.Lock()
+		t.checksum = checksum
+		t.remoteChecksum = checksum != nil
+		t.mutex.Unlock()
+	}
+	if checksum != nil {
+		if err := t.verify(ctx, checksum); err != nil {
+			return nil
 		}
 	}
+
+	// Only now does the file show up under its real name. A Pause or Cancel
+	// that came in meanwhile wins, and a later one finds the task Completed.
+	file.Close()
 	t.mutex.Lock()
-	t.status = Completed
+	if t.status != InProgress {
+		t.mutex.Unlock()
+		return nil
+	}
+	if err := os.Rename(t.partPath(), t.filePath); err != nil {
+		t.mutex.Unlock()
+		slog.Error(fmt.Sprintf("task %s | rename file failed: %v", t.filePath, err))
+		t.fail(err)
+		return nil
+	}
+	utils.ReleaseFilePath(t.filePath)
+	t.setStatus(Completed)
 	t.mutex.Unlock()
 	slog.Info(fmt.Sprintf("task %s | download finished!", t.filePath))
+	return nil
 }
 
-func (t *Task) Pause() {
+// verify checks the finished file against the expected checksum. A mismatch
+// moves the task to VerificationFailed; a pause leaves it to the next run.
+func (t *Task) verify(ctx context.Context, checksum *Checksum) error {
+	slog.Info(fmt.Sprintf("task %s | verifying %s checksum...", t.filePath, checksum.Algo))
+	err := checksum.Verify(ctx, t.partPath())
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	slog.Error(fmt.Sprintf("task %s | verification failed: %v", t.filePath, err))
+	var mismatch *ChecksumError
+	if !errors.As(err, &mismatch) {
+		t.fail(err)
+		return err
+	}
 	t.mutex.Lock()
 	if t.status == InProgress {
-		t.cancelFunc()
-		t.status = Paused
-		slog.Info(fmt.Sprintf("task %s | paused", t.filePath))
+		t.lastErr = t.recordError(err)
+		t.setStatus(VerificationFailed)
+	}
+	t.mutex.Unlock()
+	return err
+}
+
+// fail marks the task Failed and remembers why, unless it was paused or
+// canceled meanwhile.
+func (t *Task) fail(err error) {
+	t.mutex.Lock()
+	if t.status != InProgress {
+		t.mutex.Unlock()
+		return
+	}
+	t.lastErr = t.recordError(err)
+	t.setStatus(Failed)
+	t.mutex.Unlock()
+	t.persist()
+}
+
+func (t *Task) Pause() {
+	t.mutex.Lock()
+	t.pause()
+	t.mutex.Unlock()
+}
+
+// pause stops the task if it is running. The caller must hold t.mutex.
+func (t *Task) pause() bool {
+	if t.status != InProgress {
+		return false
+	}
+	t.cancelFunc()
+	t.setStatus(Paused)
+	slog.Info(fmt.Sprintf("task %s | paused", t.filePath))
+	return true
+}
+
+// running reports whether the last run started has yet to exit. The caller
+// must hold t.mutex.
+func (t *Task) running() bool {
+	if t.done == nil {
+		return false
 	}
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// waitRun waits for the last run started to exit. The caller must hold
+// t.mutex, which is released meanwhile, so the status may have changed.
+func (t *Task) waitRun() {
+	if done := t.done; done != nil {
+		t.mutex.Unlock()
+		<-done
+		t.mutex.Lock()
+	}
+}
+
+// wait blocks until the last run started has exited.
+func (t *Task) wait() {
+	t.mutex.Lock()
+	t.waitRun()
 	t.mutex.Unlock()
 }
 
 func (t *Task) Resume() {
 	t.mutex.Lock()
+	// A run that was just paused may still be winding down, and two runs
+	// must never write the same file
+	for (t.status == Paused || t.status == Pending) && t.running() {
+		t.waitRun()
+	}
 	if t.status == Paused || t.status == Pending {
 		if t.status == Pending {
 			slog.Info("new task started!")
 		} else {
 			slog.Info(fmt.Sprintf("task %s | resumed", t.filePath))
 		}
-		go t.start()
+		if t.notice == lowSpaceNotice {
+			t.notice = ""
+		}
+		t.rate.reset()
+		for _, seg := range t.segments {
+			seg.rate.reset()
+		}
+		t.ctx, t.cancelFunc = context.WithCancel(context.Background())
+		t.setStatus(InProgress)
+		t.done = make(chan struct{})
+		go t.start(t.ctx, t.done)
 	}
 	t.mutex.Unlock()
 }
 
 func (t *Task) Cancel() {
 	t.mutex.Lock()
-	if t.status == Paused || t.status == InProgress {
+	// A Pending task may be waiting on its start time or its queue's schedule
+	canceled := t.status == Pending || t.status == Paused || t.status == InProgress
+	if canceled {
 		if t.status == InProgress {
 			t.cancelFunc()
 		}
-		t.status = Canceled
-		go os.Remove(t.filePath)
+		t.setStatus(Canceled)
+		if t.filePath != "" {
+			go os.Remove(t.partPath())
+			utils.ReleaseFilePath(t.filePath)
+		}
 		slog.Info(fmt.Sprintf("task %s | canceled", t.filePath))
 	}
 	t.mutex.Unlock()
+
+	if canceled {
+		t.removeState()
+	}
+}
+
+// Remove cancels the task if it is unfinished and deletes its saved state,
+// so it is not restored on the next launch.
+func (t *Task) Remove() {
+	t.Cancel()
+	t.removeState()
+}
+
+// Suspend stops a running task and waits for its workers to exit. Unlike
+// Pause, the task is left Pending so it starts again when its queue runs.
+func (t *Task) Suspend() {
+	t.mutex.Lock()
+	suspended := false
+	for {
+		// A Resume may get in while the run winds down; stop that one too
+		if t.pause() {
+			suspended = true
+		}
+		if !t.running() {
+			break
+		}
+		t.waitRun()
+	}
+	if suspended && t.status == Paused {
+		t.setStatus(Pending)
+	}
+	t.mutex.Unlock()
+	t.persist()
+}
+
+// Retry puts a failed task back to Pending so its queue starts it again. The
+// segments finished so far and their data on disk are kept. A file that
+// failed verification is known to be bad, so that one starts over instead.
+func (t *Task) Retry() error {
+	switch t.Status() {
+	case Failed:
+	case VerificationFailed, Canceled:
+		return t.RetryFromScratch()
+	default:
+		return errNotRetryable
+	}
+	// Let the failed run finish saving its state
+	t.wait()
+
+	t.mutex.Lock()
+	t.lastErr = nil
+	t.setStatus(Pending)
+	t.mutex.Unlock()
+	t.persist()
+	slog.Info(fmt.Sprintf("task %s | retrying", t.filePath))
+	return nil
+}
+
+// RetryFromScratch throws away what a failed or canceled task downloaded and
+// puts it back to Pending, to be probed and downloaded anew.
+func (t *Task) RetryFromScratch() error {
+	status := t.Status()
+	if status != Failed && status != VerificationFailed && status != Canceled {
+		return errNotRetryable
+	}
+	t.wait()
+
+	t.mutex.Lock()
+	if status == Canceled {
+		// Cancel gave up the name, so pick one again
+		t.filePath = ""
+	} else if t.filePath != "" {
+		if err := os.Remove(t.partPath()); err != nil && !os.IsNotExist(err) {
+			slog.Error(fmt.Sprintf("task %s | remove file failed: %v", t.filePath, err))
+		}
+	}
+	t.segments = nil
+	t.probed = false
+	t.fileSize = -1
+	t.etag = ""
+	t.lastModified = ""
+	t.notice = ""
+	t.lastErr = nil
+	t.setStatus(Pending)
+	t.mutex.Unlock()
+	t.persist()
+	slog.Info(fmt.Sprintf("task %s | restarting from scratch", t.filePath))
+	return nil
+}
+
+// SetClient sets the HTTP client used for the task's requests.
+func (t *Task) SetClient(client *http.Client) {
+	t.mutex.Lock()
+	t.client = client
+	t.mutex.Unlock()
+}
+
+// SetStartAt makes the queue wait until at before starting the task. The
+// zero time lets it start right away.
+func (t *Task) SetStartAt(at time.Time) {
+	t.mutex.Lock()
+	t.startAt = at
+	t.mutex.Unlock()
+	t.persist()
+}
+
+func (t *Task) StartAt() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.startAt
+}
+
+// SetSpeedLimit caps the task in KB/s on top of its queue's limit. 0 leaves
+// only the queue's.
+func (t *Task) SetSpeedLimit(limit uint64) {
+	t.limiter.SetRate(limit * 1024)
+	t.persist()
+}
+
+// SpeedLimit returns the task's own limit in KB/s, 0 if it has none.
+func (t *Task) SpeedLimit() uint64 {
+	return t.limiter.Rate() / 1024
+}
+
+// SetChecksum sets the digest the downloaded file must match, given as
+// algo:hex. An empty string removes it.
+func (t *Task) SetChecksum(checksum string) error {
+	var c *Checksum
+	if checksum != "" {
+		var err error
+		if c, err = ParseChecksum(checksum); err != nil {
+			return err
+		}
+	}
+	t.mutex.Lock()
+	t.checksum = c
+	t.remoteChecksum = false
+	t.mutex.Unlock()
+	return nil
+}
+
+// SetAutoChecksum makes the task look for a checksum file published next to
+// the download, such as SHA256SUMS or <name>.sha256, when none was given.
+func (t *Task) SetAutoChecksum(enabled bool) {
+	t.mutex.Lock()
+	t.autoChecksum = enabled
+	t.mutex.Unlock()
 }
 
-//func (t *Task)Retry()  {
-//	//t.mutex.Loc
-//	t.Cancel()
-//	t.cancelFunc()
-//	t.start()
-//	//t.mutex.Unlock()
-//
-//}
+// Checksum returns the expected digest as algo:hex, or "" if there is none.
+func (t *Task) Checksum() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.checksum == nil {
+		return ""
+	}
+	return t.checksum.String()
+}
 
 func (t *Task) Url() string {
 	return t.url
 }
 
 func (t *Task) Status() DownloadStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	return t.status
 }
 
+// Notice is a short message about something the user should know, such as
+// the download having been restarted.
+func (t *Task) Notice() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.notice
+}
+
+// LastError is the reason the task failed, if any. It is a TaskError, so
+// its kind is at hand.
+func (t *Task) LastError() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.lastErr
+}
+
 func (t *Task) TotalSize() int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	return t.fileSize
 }
 
 func (t *Task) Downloaded() uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	totalDownloaded := uint64(0)
-	for _, d := range t.downloaded {
-		totalDownloaded += d
+	for _, seg := range t.segments {
+		totalDownloaded += seg.downloaded
 	}
 	return totalDownloaded
 }
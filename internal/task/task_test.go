@@ -0,0 +1,101 @@
+package task
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowReader serves its data a little at a time, so downloads take long
+// enough to be paused.
+type slowReader struct {
+	*bytes.Reader
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return r.Reader.Read(p[:min(len(p), 4<<10)])
+}
+
+func TestConcurrentPauseResumeSuspend(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Unix(1000, 0), slowReader{bytes.NewReader(data)})
+	}))
+	defer srv.Close()
+
+	task := NewTask(srv.URL+"/file.bin", t.TempDir(), 4, 3, nil)
+	task.Resume()
+
+	// The TUI, the queue scheduler and a window closing all at once
+	stop := time.Now().Add(500 * time.Millisecond)
+	var wg sync.WaitGroup
+	for _, op := range []func(){task.Pause, task.Resume, task.Resume, task.Suspend} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				op()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Nothing may be left running behind a Suspend
+	task.Suspend()
+	task.mutex.Lock()
+	running := task.running()
+	task.mutex.Unlock()
+	if running || task.Status() == InProgress {
+		t.Fatalf("a run survived Suspend, status %v", task.Status())
+	}
+
+	task.Resume()
+	waitDone(t, task)
+	if status := task.Status(); status != Completed {
+		t.Fatalf("status = %v, want Completed (last error: %v)", status, task.LastError())
+	}
+	got, err := os.ReadFile(task.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match the original")
+	}
+}
+
+func TestResumeWaitsForPausedRun(t *testing.T) {
+	data := make([]byte, 512<<10)
+	rand.Read(data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Unix(1000, 0), slowReader{bytes.NewReader(data)})
+	}))
+	defer srv.Close()
+
+	task := NewTask(srv.URL+"/file.bin", t.TempDir(), 2, 1, nil)
+	task.Resume()
+	for i := 0; i < 20; i++ {
+		time.Sleep(10 * time.Millisecond)
+		task.Pause()
+		task.Resume()
+	}
+	waitDone(t, task)
+
+	if status := task.Status(); status != Completed {
+		t.Fatalf("status = %v, want Completed (last error: %v)", status, task.LastError())
+	}
+	got, err := os.ReadFile(task.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("downloaded file does not match the original")
+	}
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a standard 5-field cron expression: minute, hour, day of month,
+// month and day of week.
+type Cron struct {
+	expr     string
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	domStar  bool
+	dowStar  bool
+	location *time.Location // nil for local time
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dowNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseCron reads a cron expression such as "30 22 * * 1-5" or "@daily",
+// optionally preceded by a time zone as in "TZ=Europe/Berlin 0 8 * * *".
+// Fields take *, numbers, names of months and days, ranges, lists and steps.
+// As in cron, when both the day of month and the day of week are
+// restricted, a time matching either runs.
+func ParseCron(s string) (*Cron, error) {
+	c := &Cron{expr: strings.TrimSpace(s)}
+	fields := strings.Fields(s)
+	if len(fields) > 0 {
+		zone, ok := strings.CutPrefix(fields[0], "TZ=")
+		if !ok {
+			zone, ok = strings.CutPrefix(fields[0], "CRON_TZ=")
+		}
+		if ok {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				return nil, fmt.Errorf("unknown time zone %q", zone)
+			}
+			c.location = loc
+			fields = fields[1:]
+		}
+	}
+	if len(fields) == 1 {
+		macro, ok := cronMacros[strings.ToLower(fields[0])]
+		if !ok {
+			return nil, fmt.Errorf("unknown cron macro %q", fields[0])
+		}
+		fields = strings.Fields(macro)
+	}
+	if len(fields) != 5 {
+		return nil, errors.New("cron expression needs 5 fields: minute hour day-of-month month day-of-week")
+	}
+
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// 7 is Sunday too
+	if c.dow, err = parseCronField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField returns the values a field allows as a bit set.
+func parseCronField(field string, low, high int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if name != "" && strings.EqualFold(s, name) {
+				return i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < low || n > high {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		from, to := low, high
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = value(first); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = value(last); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" runs from 5 to the end
+				to = high
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after now the expression matches, or the zero
+// time if it never does, like "0 0 30 2 *".
+func (c *Cron) Next(now time.Time) time.Time {
+	loc := now.Location()
+	if c.location != nil {
+		loc = c.location
+	}
+	t := now.In(loc).Truncate(time.Minute).Add(time.Minute)
+
+	// Whole years repeat after at most 28 of them
+	limit := t.AddDate(28, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) String() string {
+	return c.expr
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Monday 12 October 2026, 10:00 UTC
+	monday := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		expr string
+		now  time.Time
+		want time.Time // zero if it never matches
+	}{
+		{"30 22 * * 1-5", monday, date(2026, 10, 12, 22, 30)},
+		{"30 22 * * 1-5", date(2026, 10, 16, 23, 0), date(2026, 10, 19, 22, 30)},
+		{"0 10 * * *", monday, date(2026, 10, 13, 10, 0)},
+		{"* * * * *", monday.Add(30 * time.Second), date(2026, 10, 12, 10, 1)},
+
+		// Restricting both days matches either of them
+		{"0 0 13 * 5", monday, date(2026, 10, 13, 0, 0)},
+		{"0 0 13 * 5", date(2026, 10, 13, 0, 0), date(2026, 10, 16, 0, 0)},
+		{"0 0 13 * *", date(2026, 10, 13, 0, 0), date(2026, 11, 13, 0, 0)},
+		{"0 0 * * 5", date(2026, 10, 13, 0, 0), date(2026, 10, 16, 0, 0)},
+		// A field starting with * doesn't restrict, as in cron, so both match
+		{"0 0 */2 * 5", monday, date(2026, 10, 23, 0, 0)},
+
+		// 7 is Sunday, like 0
+		{"0 0 * * 7", monday, date(2026, 10, 18, 0, 0)},
+		{"0 0 * * 0", monday, date(2026, 10, 18, 0, 0)},
+		{"0 0 * * sun", monday, date(2026, 10, 18, 0, 0)},
+		{"0 0 * * 6-7", monday, date(2026, 10, 17, 0, 0)},
+		{"0 0 * * 5-7", date(2026, 10, 17, 12, 0), date(2026, 10, 18, 0, 0)},
+
+		// Steps
+		{"5/20 * * * *", monday, date(2026, 10, 12, 10, 5)},
+		{"5/20 * * * *", date(2026, 10, 12, 10, 5), date(2026, 10, 12, 10, 25)},
+		{"5/20 * * * *", date(2026, 10, 12, 10, 45), date(2026, 10, 12, 11, 5)},
+		{"*/15 9-17 * * *", monday, date(2026, 10, 12, 10, 15)},
+		{"0 8-18/4 * * *", monday, date(2026, 10, 12, 12, 0)},
+		{"0,30 22 * * mon,wed", monday, date(2026, 10, 12, 22, 0)},
+
+		// Names, macros and months further ahead
+		{"0 9 * jan-mar mon", monday, date(2027, 1, 4, 9, 0)},
+		{"@daily", monday, date(2026, 10, 13, 0, 0)},
+		{"@weekly", monday, date(2026, 10, 18, 0, 0)},
+		{"@yearly", monday, date(2027, 1, 1, 0, 0)},
+
+		// Leap days, 2100 not being one, and days that never come
+		{"0 0 29 2 *", monday, date(2028, 2, 29, 0, 0)},
+		{"0 0 29 2 *", date(2097, 3, 1, 0, 0), date(2104, 2, 29, 0, 0)},
+		{"0 0 30 2 *", monday, time.Time{}},
+		{"0 0 31 4,6,9,11 *", monday, time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := c.Next(tt.now); !got.Equal(tt.want) {
+			t.Errorf("%q after %v: Next() = %v, want %v", tt.expr, tt.now, got, tt.want)
+		}
+		if got := c.String(); got != tt.expr {
+			t.Errorf("String() = %q, want %q", got, tt.expr)
+		}
+	}
+}
+
+func TestCronTimeZone(t *testing.T) {
+	if _, err := time.LoadLocation("Asia/Tokyo"); err != nil {
+		t.Skip(err)
+	}
+	// 10:00 UTC is 19:00 in Tokyo, so 09:00 there is midnight UTC tomorrow
+	now := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+	want := time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC)
+	for _, expr := range []string{"TZ=Asia/Tokyo 0 9 * * *", "CRON_TZ=Asia/Tokyo 0 9 * * *"} {
+		c, err := ParseCron(expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", expr, err)
+		}
+		if got := c.Next(now); !got.Equal(want) {
+			t.Errorf("%q: Next() = %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@fortnightly",
+		"TZ=Nowhere/Else 0 0 * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) accepted an invalid expression", expr)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// filesystem holding dir.
+func FreeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// Preallocate is not supported here.
+func Preallocate(file *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
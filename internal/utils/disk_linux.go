@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// filesystem holding dir.
+func FreeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// Preallocate reserves size bytes for file so the disk cannot fill up halfway
+// through writing it.
+func Preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		return errors.ErrUnsupported
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+// FreeSpace is not supported here.
+func FreeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// Preallocate is not supported here.
+func Preallocate(file *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -4,33 +4,179 @@ import (
 	"fmt"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
+// PartSuffix is appended to the name of a file while it is being downloaded.
+const PartSuffix = ".part"
+
+var (
+	reservedMutex sync.Mutex
+	reserved      = make(map[string]bool)
+)
+
+// FileName works out the name to save a response under: the
+// Content-Disposition filename, preferring an RFC 5987 filename*, or else the
+// last segment of the URL path. A name without an extension gets one from the
+// Content-Type. The name comes from the server, so it is sanitized.
 func FileName(resp *http.Response) string {
-	cd := resp.Header.Get("Content-Disposition")
-	if cd != "" && strings.Contains(cd, "filename=") {
-		parts := strings.Split(cd, "filename=")
-		return strings.Trim(parts[1], "\"")
-	}
-
-	filename := path.Base(resp.Request.URL.Path)
-	if filename == "" || filename == "/" {
-		contentType := resp.Header.Get("Content-Type")
-		exts, err := mime.ExtensionsByType(contentType)
-		if err == nil && len(exts) > 0 {
-			return "unknown_file" + exts[0]
-		}
-		return "unknown_file"
+	contentType := resp.Header.Get("Content-Type")
+	filename := SanitizeFileName(dispositionFileName(resp.Header.Get("Content-Disposition")))
+	if filename == "" && resp.Request != nil {
+		filename = SanitizeFileName(urlFileName(resp.Request.URL))
+	}
+	if filename == "" {
+		return "unknown_file" + typeExtension(contentType)
+	}
+	if path.Ext(filename) == "" && !strings.HasPrefix(contentType, "application/octet-stream") {
+		filename += typeExtension(contentType)
 	}
 	return filename
 }
+
+// dispositionFileName extracts the filename from a Content-Disposition
+// header. mime.ParseMediaType decodes filename* in UTF-8 and prefers it, but
+// rejects the malformed headers some servers send, so those and other
+// charsets are handled by a lenient parser.
+func dispositionFileName(cd string) string {
+	if cd == "" {
+		return ""
+	}
+	if _, params, err := mime.ParseMediaType(cd); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+
+	params := dispositionParams(cd)
+	if name, ok := decodeExtValue(params["filename*"]); ok && name != "" {
+		return name
+	}
+	return params["filename"]
+}
+
+// dispositionParams splits a header into its parameters, keyed by lower case
+// name, tolerating unquoted spaces and a missing disposition type.
+func dispositionParams(cd string) map[string]string {
+	params := make(map[string]string)
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(cd); i++ {
+		switch cd[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case ';':
+			if !quoted {
+				parts = append(parts, cd[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, cd[start:])
+
+	for _, part := range parts {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = unquote(value[1 : len(value)-1])
+		}
+		if _, exists := params[key]; !exists {
+			params[key] = value
+		}
+	}
+	return params
+}
+
+func unquote(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// decodeExtValue decodes an RFC 5987 value, charset'language'percent-encoded.
+func decodeExtValue(v string) (string, bool) {
+	parts := strings.SplitN(v, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	value, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8", "us-ascii":
+		return value, utf8.ValidString(value)
+	case "iso-8859-1":
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+		}
+		return string(runes), true
+	}
+	return "", false
+}
+
+// urlFileName returns the last segment of the URL path, percent-decoded.
+func urlFileName(u *url.URL) string {
+	escaped := strings.TrimRight(u.EscapedPath(), "/")
+	name := escaped[strings.LastIndex(escaped, "/")+1:]
+	if decoded, err := url.PathUnescape(name); err == nil {
+		name = decoded
+	}
+	return name
+}
+
+// preferredExtensions picks the usual extension where mime knows several.
+var preferredExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"text/plain":      ".txt",
+	"text/html":       ".html",
+	"audio/mpeg":      ".mp3",
+	"video/mpeg":      ".mpeg",
+	"application/xml": ".xml",
+}
+
+// typeExtension returns the extension for a Content-Type, if it has one.
+func typeExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
+
 // findUniqueFilePath checks if `path` exists. If it does, it appends (1), (2), etc.
 // before the file extension until it finds a path that does not exist.
+// Paths reserved by other downloads or with a .part file next to them count
+// as existing, and the returned path stays reserved until ReleaseFilePath.
 func FindUniqueFilePath(path string) string {
+	reservedMutex.Lock()
+	defer reservedMutex.Unlock()
+
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
 	ext := filepath.Ext(base)
@@ -41,8 +187,10 @@ func FindUniqueFilePath(path string) string {
 
 	for {
 		_, err := os.Stat(candidate)
-		if os.IsNotExist(err) {
+		_, partErr := os.Stat(candidate + PartSuffix)
+		if os.IsNotExist(err) && os.IsNotExist(partErr) && !reserved[candidate] {
 			// This candidate doesn't exist, so we can use it
+			reserved[candidate] = true
 			return candidate
 		}
 		// File exists, so build a new candidate with (i)
@@ -53,3 +201,19 @@ func FindUniqueFilePath(path string) string {
 		i++
 	}
 }
+
+// ReserveFilePath keeps FindUniqueFilePath from handing out path, for
+// downloads restored from a previous session.
+func ReserveFilePath(path string) {
+	reservedMutex.Lock()
+	reserved[path] = true
+	reservedMutex.Unlock()
+}
+
+// ReleaseFilePath gives up a reservation once the file exists or the download
+// was abandoned.
+func ReleaseFilePath(path string) {
+	reservedMutex.Lock()
+	delete(reserved, path)
+	reservedMutex.Unlock()
+}
@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		disposition string
+		contentType string
+		want        string
+	}{
+		{
+			name:        "quoted filename",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename="report.pdf"`,
+			want:        "report.pdf",
+		},
+		{
+			name:        "token filename",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename=report.pdf`,
+			want:        "report.pdf",
+		},
+		{
+			name:        "trailing parameters",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename="a.zip"; size=123`,
+			want:        "a.zip",
+		},
+		{
+			name:        "utf-8 filename star",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename*=UTF-8''na%C3%AFve.pdf`,
+			want:        "naïve.pdf",
+		},
+		{
+			name:        "filename star preferred over filename",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename="EURO rates.pdf"; filename*=utf-8''%e2%82%ac%20rates.pdf`,
+			want:        "€ rates.pdf",
+		},
+		{
+			name:        "filename star before filename",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename*=UTF-8''%E6%97%A5%E6%9C%AC.txt; filename="nihon.txt"`,
+			want:        "日本.txt",
+		},
+		{
+			name:        "iso-8859-1 filename star",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename*=iso-8859-1'en'%A3%20rates.pdf`,
+			want:        "£ rates.pdf",
+		},
+		{
+			name:        "unknown charset falls back to filename",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename*=x-unknown''abc.bin; filename="plain.bin"`,
+			want:        "plain.bin",
+		},
+		{
+			name:        "escaped quote",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename="say \"hi\".txt"`,
+			want:        "say _hi_.txt", // quotes are invalid on Windows
+		},
+		{
+			name:        "semicolon inside quotes",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename="a;b.txt"`,
+			want:        "a;b.txt",
+		},
+		{
+			name:        "unquoted spaces",
+			url:         "https://example.com/download",
+			disposition: `attachment; filename=my file.zip`,
+			want:        "my file.zip",
+		},
+		{
+			name:        "no disposition type",
+			url:         "https://example.com/download",
+			disposition: `filename="bare.tar.gz"`,
+			want:        "bare.tar.gz",
+		},
+		{
+			name:        "upper case parameter",
+			url:         "https://example.com/download",
+			disposition: `Attachment; FILENAME="upper.iso"`,
+			want:        "upper.iso",
+		},
+		{
+			name:        "inline without filename uses url",
+			url:         "https://example.com/files/video.mp4",
+			disposition: `inline`,
+			want:        "video.mp4",
+		},
+		{
+			name: "url path",
+			url:  "https://example.com/files/archive.tar.gz?token=abc",
+			want: "archive.tar.gz",
+		},
+		{
+			name: "percent-encoded url path",
+			url:  "https://example.com/files/na%C3%AFve%20file.pdf",
+			want: "naïve file.pdf",
+		},
+		{
+			name: "trailing slash",
+			url:  "https://example.com/files/latest/",
+			want: "latest",
+		},
+		{
+			name:        "extension from content type",
+			url:         "https://example.com/download",
+			contentType: "application/pdf",
+			want:        "download.pdf",
+		},
+		{
+			name:        "content type with parameters",
+			url:         "https://example.com/readme",
+			contentType: "text/plain; charset=utf-8",
+			want:        "readme.txt",
+		},
+		{
+			name:        "octet-stream adds no extension",
+			url:         "https://example.com/bin/tool",
+			contentType: "application/octet-stream",
+			want:        "tool",
+		},
+		{
+			name:        "no name at all",
+			url:         "https://example.com/",
+			contentType: "image/jpeg",
+			want:        "unknown_file.jpg",
+		},
+		{
+			name:        "path traversal in disposition",
+			url:         "https://example.com/download.bin",
+			disposition: `attachment; filename="../../.bashrc"`,
+			want:        "bashrc",
+		},
+		{
+			name:        "encoded traversal in filename star",
+			url:         "https://example.com/download.bin",
+			disposition: `attachment; filename*=UTF-8''..%2F..%2Fetc%2Fpasswd`,
+			want:        "passwd",
+		},
+		{
+			name:        "disposition with nothing usable falls back to url",
+			url:         "https://example.com/files/data.csv",
+			disposition: `attachment; filename=".."`,
+			want:        "data.csv",
+		},
+		{
+			name: "encoded slash in url path",
+			url:  "https://example.com/files/..%2F..%2Fevil.sh",
+			want: "evil.sh",
+		},
+		{
+			name: "no name or type",
+			url:  "https://example.com",
+			want: "unknown_file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := &http.Response{
+				Header:  make(http.Header),
+				Request: &http.Request{URL: u},
+			}
+			if tt.disposition != "" {
+				resp.Header.Set("Content-Disposition", tt.disposition)
+			}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+			if got := FileName(resp); got != tt.want {
+				t.Errorf("FileName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	long := strings.Repeat("a", 300) + ".tar.gz"
+	longUnicode := strings.Repeat("é", 200) + ".txt"
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../.bashrc", "bashrc"},
+		{`..\..\windows\system32\evil.dll`, "evil.dll"},
+		{"/etc/passwd", "passwd"},
+		{"dir/", ""},
+		{"..", ""},
+		{".", ""},
+		{"", ""},
+		{"...hidden", "hidden"},
+		{"name.  ", "name"},
+		{"  spaced name.txt ", "spaced name.txt"},
+		{"a\x00b\x1fc\x7f.txt", "abc.txt"},
+		{"new\nline.txt", "newline.txt"},
+		{"invoice\u202egnp.exe", "invoicegnp.exe"},
+		{`what?<is>:this|"*.txt`, "what__is__this___.txt"},
+		{"CON", "_CON"},
+		{"con.txt", "_con.txt"},
+		{"LPT1.tar.gz", "_LPT1.tar.gz"},
+		{"COM10.txt", "COM10.txt"},
+		{"console.log", "console.log"},
+		{"naïve résumé.pdf", "naïve résumé.pdf"},
+		{"日本語.txt", "日本語.txt"},
+		{long, strings.Repeat("a", maxFileNameBytes-len(".gz")) + ".gz"},
+		{longUnicode, strings.Repeat("é", (maxFileNameBytes-len(".txt"))/2) + ".txt"},
+	}
+
+	for _, tt := range tests {
+		got := SanitizeFileName(tt.in)
+		if got != tt.want {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if len(got) > maxFileNameBytes || !utf8.ValidString(got) {
+			t.Errorf("SanitizeFileName(%q) = %q is too long or invalid", tt.in, got)
+		}
+	}
+}
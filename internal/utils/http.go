@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// HTTPOptions configures the client a queue downloads with.
+type HTTPOptions struct {
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// ReadTimeout aborts a connection that received nothing for that long.
+	// It is not a limit on the whole download.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// Proxy is an http://, https:// or socks5:// URL. Empty uses the
+	// HTTP_PROXY and HTTPS_PROXY environment variables, "direct" none.
+	Proxy string `yaml:"proxy"`
+
+	// CACertFile is a PEM bundle trusted on top of the system roots.
+	CACertFile         string `yaml:"ca_cert_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+
+	UserAgent    string `yaml:"user_agent"`
+	MaxIdleConns int    `yaml:"max_idle_conns"`
+}
+
+func DefaultHTTPOptions() HTTPOptions {
+	return HTTPOptions{
+		ConnectTimeout: 30 * time.Second,
+		ReadTimeout:    time.Minute,
+		IdleTimeout:    90 * time.Second,
+		UserAgent:      "go-download-manager",
+		MaxIdleConns:   100,
+	}
+}
+
+// NewHTTPClient builds a client from opts. It has no overall timeout since a
+// download may take hours; stalled connections are caught by ReadTimeout.
+func NewHTTPClient(opts HTTPOptions) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	switch opts.Proxy {
+	case "":
+	case "direct":
+		proxy = nil
+	default:
+		u, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CACertFile != "" {
+		pem, err := os.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificates: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + opts.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || opts.ReadTimeout <= 0 {
+				return conn, err
+			}
+			return &timeoutConn{conn, opts.ReadTimeout}, nil
+		},
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ResponseHeaderTimeout: opts.ReadTimeout,
+		IdleConnTimeout:       opts.IdleTimeout,
+		MaxIdleConns:          opts.MaxIdleConns,
+		// Segments of a file all go to the same host
+		MaxIdleConnsPerHost: opts.MaxIdleConns,
+		ForceAttemptHTTP2:   true,
+	}
+
+	var rt http.RoundTripper = transport
+	if opts.UserAgent != "" {
+		rt = &userAgentTransport{transport, opts.UserAgent}
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// timeoutConn pushes its read deadline back before every read, so only a
+// connection that stalls times out.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+type userAgentTransport struct {
+	base  http.RoundTripper
+	agent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.agent)
+	return t.base.RoundTrip(req)
+}
+
+// RedactURL makes a URL safe to log: the password is masked and the query,
+// where presigned links keep their tokens, is dropped.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid url>"
+	}
+	u.RawQuery, u.ForceQuery, u.Fragment = "", false, ""
+	return u.Redacted()
+}
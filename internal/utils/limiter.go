@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minBurst lets a whole read buffer through even at very low rates.
+const minBurst = 32 * 1024
+
+// maxLimiterSleep bounds how long a waiter sleeps before looking again, so a
+// rate change takes effect right away.
+const maxLimiterSleep = 100 * time.Millisecond
+
+// Limiter is a token bucket counting bytes. It refills at its rate and holds
+// at most a second's worth of tokens. A nil Limiter or a rate of 0 means no
+// limit. Limiters are shared by pointer, so SetRate applies to every
+// download using one.
+//
+// Limiters form a hierarchy, such as global, queue and task: bytes must pass
+// a limiter and all its parents. Waiters are served in arrival order and each
+// child has at most one request waiting on its parent at a time, so the
+// parent's budget is shared evenly between busy children however many
+// connections each of them runs.
+type Limiter struct {
+	mutex    sync.Mutex
+	base     uint64  // bytes per second, as set
+	rate     float64 // bytes per second, with the schedule applied
+	burst    float64
+	tokens   float64
+	last     time.Time
+	parent   *Limiter
+	schedule *BandwidthSchedule
+
+	turn     chan struct{} // held by the waiter taking tokens
+	upstream chan struct{} // held while waiting on the parent
+}
+
+// NewLimiter returns a limiter allowing rate bytes per second.
+func NewLimiter(rate uint64) *Limiter {
+	l := &Limiter{turn: make(chan struct{}, 1), upstream: make(chan struct{}, 1)}
+	l.SetRate(rate)
+	return l
+}
+
+// NewChildLimiter returns a limiter under parent.
+func NewChildLimiter(parent *Limiter, rate uint64) *Limiter {
+	l := NewLimiter(rate)
+	l.parent = parent
+	return l
+}
+
+// SetParent moves the limiter under parent, or to the top if nil.
+func (l *Limiter) SetParent(parent *Limiter) {
+	l.mutex.Lock()
+	l.parent = parent
+	l.mutex.Unlock()
+}
+
+// SetRate changes the rate, in bytes per second. 0 removes the limit.
+func (l *Limiter) SetRate(rate uint64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.base = rate
+	l.refill(time.Now())
+}
+
+// SetSchedule makes the rate follow schedule, falling back to the rate set
+// with SetRate outside its rules. The change of rate at a rule's boundary
+// applies to waiting downloads without interrupting them.
+func (l *Limiter) SetSchedule(schedule *BandwidthSchedule) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.schedule = schedule
+	l.refill(time.Now())
+}
+
+// Schedule returns the schedule set with SetSchedule, if any.
+func (l *Limiter) Schedule() *BandwidthSchedule {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.schedule
+}
+
+// Rate returns the rate set with SetRate in bytes per second, 0 if unlimited.
+func (l *Limiter) Rate() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.base
+}
+
+// CurrentRate returns the rate in force now, in bytes per second.
+func (l *Limiter) CurrentRate() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill(time.Now())
+	return uint64(l.rate)
+}
+
+// refill adds the tokens earned since the last call, then picks up a change
+// of rate, scheduled or not.
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	rate := l.base
+	if kbps, ok := l.schedule.RateAt(now); ok {
+		rate = kbps * 1024
+	}
+	if float64(rate) == l.rate && l.burst > 0 {
+		return
+	}
+	l.rate = float64(rate)
+	l.burst = max(l.rate, minBurst)
+	if rate == 0 || l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// WaitN blocks until n bytes may pass this limiter and its parents, or ctx
+// is done.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.take(ctx, n); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	parent := l.parent
+	l.mutex.Unlock()
+	if parent == nil {
+		return nil
+	}
+	if err := acquire(ctx, l.upstream); err != nil {
+		return err
+	}
+	defer func() { <-l.upstream }()
+	return parent.WaitN(ctx, n)
+}
+
+// acquire takes a one-slot semaphore. Blocked senders on a channel are
+// woken first come, first served.
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// take waits for n tokens of this limiter's own bucket.
+func (l *Limiter) take(ctx context.Context, n int) error {
+	if l.CurrentRate() == 0 {
+		return nil
+	}
+	if err := acquire(ctx, l.turn); err != nil {
+		return err
+	}
+	defer func() { <-l.turn }()
+
+	for remaining := float64(n); remaining > 0; {
+		l.mutex.Lock()
+		l.refill(time.Now())
+		if l.rate == 0 {
+			l.mutex.Unlock()
+			return nil
+		}
+		want := min(remaining, l.burst)
+		if l.tokens >= want {
+			l.tokens -= want
+			remaining -= want
+			l.mutex.Unlock()
+			continue
+		}
+		wait := time.Duration((want - l.tokens) / l.rate * float64(time.Second))
+		l.mutex.Unlock()
+
+		timer := time.NewTimer(min(wait, maxLimiterSleep))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
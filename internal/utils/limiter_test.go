@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pump calls WaitN with chunks of 32 KB from conns goroutines until ctx is
+// done, and returns how many bytes got through.
+func pump(ctx context.Context, l *Limiter, conns int) *atomic.Int64 {
+	var passed atomic.Int64
+	for i := 0; i < conns; i++ {
+		go func() {
+			for l.WaitN(ctx, 32<<10) == nil {
+				passed.Add(32 << 10)
+			}
+		}()
+	}
+	return &passed
+}
+
+func TestLimiterRate(t *testing.T) {
+	const rate = 500 << 10
+	// A new limiter starts with an empty bucket
+	l := NewLimiter(rate)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	passed := pump(ctx, l, 3)
+	<-ctx.Done()
+
+	// 2 seconds at 500 KB/s, give or take a chunk per connection
+	got := float64(passed.Load())
+	if want := 2.0 * rate; got < want*0.85 || got > want*1.15 {
+		t.Errorf("passed %.0f KB in 2s, want about %.0f KB", got/1024, want/1024)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	var l *Limiter
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := NewLimiter(0).WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("unlimited WaitN took %v", elapsed)
+	}
+}
+
+func TestLimiterChangeWhileWaiting(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(l *Limiter)
+	}{
+		{"remove the limit", func(l *Limiter) { l.SetRate(0) }},
+		{"raise the limit", func(l *Limiter) { l.SetRate(100 << 20) }},
+		{"schedule a higher limit", func(l *Limiter) {
+			schedule, err := ParseBandwidthSchedule("00:00-00:00 100000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.SetSchedule(schedule)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// At 10 KB/s, 256 KB take 25 seconds
+			l := NewLimiter(10 << 10)
+			done := make(chan error, 1)
+			go func() { done <- l.WaitN(context.Background(), 256<<10) }()
+
+			time.Sleep(50 * time.Millisecond)
+			start := time.Now()
+			tt.change(l)
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the change did not reach the waiting WaitN")
+			}
+			if elapsed := time.Since(start); elapsed > 2*maxLimiterSleep {
+				t.Errorf("WaitN returned %v after the change", elapsed)
+			}
+		})
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := NewLimiter(10 << 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 256<<10); err != context.DeadlineExceeded {
+		t.Fatalf("WaitN() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+func TestChildLimiterHonoursParent(t *testing.T) {
+	const rate = 200 << 10
+	parent := NewLimiter(rate)
+	child := NewChildLimiter(parent, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	passed := pump(ctx, child, 2)
+	<-ctx.Done()
+
+	got := float64(passed.Load())
+	if want := float64(rate); got < want*0.8 || got > want*1.2 {
+		t.Errorf("child passed %.0f KB in 1s, want about %.0f KB", got/1024, want/1024)
+	}
+}
+
+func TestChildLimitersShareParentEvenly(t *testing.T) {
+	const rate = 400 << 10
+	parent := NewLimiter(rate)
+	busy := NewChildLimiter(parent, 0)
+	single := NewChildLimiter(parent, 0)
+
+	// One task with 4 connections, one with a single connection
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	busyPassed := pump(ctx, busy, 4)
+	singlePassed := pump(ctx, single, 1)
+	<-ctx.Done()
+
+	b, s := float64(busyPassed.Load()), float64(singlePassed.Load())
+	if total, want := b+s, 2.0*rate; total < want*0.85 || total > want*1.15 {
+		t.Errorf("passed %.0f KB in 2s together, want about %.0f KB", total/1024, want/1024)
+	}
+	if ratio := b / s; ratio < 0.75 || ratio > 1.33 {
+		t.Errorf("4 connections got %.0f KB, 1 connection %.0f KB, want an even split", b/1024, s/1024)
+	}
+}
+
+func TestChildLimiterOwnRate(t *testing.T) {
+	const rate = 100 << 10
+	parent := NewLimiter(1 << 20)
+	child := NewChildLimiter(parent, rate)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	passed := pump(ctx, child, 2)
+	<-ctx.Done()
+
+	got := float64(passed.Load())
+	if want := 2.0 * rate; got < want*0.8 || got > want*1.2 {
+		t.Errorf("child passed %.0f KB in 2s, want about %.0f KB", got/1024, want/1024)
+	}
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// Netrc holds the credentials of a .netrc file, as used by curl and wget.
+type Netrc struct {
+	machines map[string]netrcEntry
+	fallback *netrcEntry // the "default" entry
+}
+
+// DefaultNetrcPath returns $NETRC if set, ~/.netrc otherwise (~/_netrc on
+// Windows).
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc reads a .netrc file. A missing file yields nil and no error.
+func LoadNetrc(path string) (*Netrc, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseNetrc(string(data)), nil
+}
+
+// ParseNetrc parses the contents of a .netrc file. Macro definitions are
+// skipped, and so is anything it does not understand.
+func ParseNetrc(data string) *Netrc {
+	n := &Netrc{machines: make(map[string]netrcEntry)}
+	var (
+		current *netrcEntry
+		machine string
+		inMacro bool
+	)
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if machine == "" {
+			n.fallback = current
+		} else if _, ok := n.machines[machine]; !ok {
+			// Like curl, the first entry for a machine wins
+			n.machines[machine] = *current
+		}
+		current = nil
+	}
+
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			// A macro runs until an empty line
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			var value string
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				flush()
+				current, machine = &netrcEntry{}, strings.ToLower(value)
+				i++
+			case "default":
+				flush()
+				current, machine = &netrcEntry{}, ""
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				flush()
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	flush()
+	return n
+}
+
+// Lookup returns the credentials for host, falling back to the default
+// entry.
+func (n *Netrc) Lookup(host string) (login, password string, ok bool) {
+	if n == nil {
+		return "", "", false
+	}
+	if e, found := n.machines[strings.ToLower(host)]; found {
+		return e.login, e.password, true
+	}
+	if n.fallback != nil {
+		return n.fallback.login, n.fallback.password, true
+	}
+	return "", "", false
+}
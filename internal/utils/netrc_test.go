@@ -0,0 +1,57 @@
+package utils
+
+import "testing"
+
+func TestParseNetrc(t *testing.T) {
+	const data = `# credentials
+machine example.com login alice password secret1
+machine Files.Example.com
+	login bob
+	password secret2
+	account ignored
+
+macdef init
+	machine evil.com login mallory password stolen
+	cd /pub
+
+machine example.com login carol password secret3
+default login anonymous password guest@
+`
+	tests := []struct {
+		host      string
+		wantLogin string
+		wantPass  string
+		wantOK    bool
+	}{
+		{host: "example.com", wantLogin: "alice", wantPass: "secret1", wantOK: true},
+		{host: "files.example.com", wantLogin: "bob", wantPass: "secret2", wantOK: true},
+		{host: "EXAMPLE.COM", wantLogin: "alice", wantPass: "secret1", wantOK: true},
+		{host: "evil.com", wantLogin: "anonymous", wantPass: "guest@", wantOK: true},
+		{host: "other.org", wantLogin: "anonymous", wantPass: "guest@", wantOK: true},
+	}
+	n := ParseNetrc(data)
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			login, password, ok := n.Lookup(tt.host)
+			if login != tt.wantLogin || password != tt.wantPass || ok != tt.wantOK {
+				t.Errorf("Lookup(%q) = %q, %q, %v, want %q, %q, %v", tt.host, login, password, ok,
+					tt.wantLogin, tt.wantPass, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseNetrcWithoutDefault(t *testing.T) {
+	n := ParseNetrc("machine example.com login alice password secret # comment\n")
+	if login, password, ok := n.Lookup("example.com"); !ok || login != "alice" || password != "secret" {
+		t.Errorf("Lookup(example.com) = %q, %q, %v", login, password, ok)
+	}
+	if _, _, ok := n.Lookup("other.org"); ok {
+		t.Error("Lookup(other.org) found credentials without a default entry")
+	}
+
+	var missing *Netrc
+	if _, _, ok := missing.Lookup("example.com"); ok {
+		t.Error("nil Netrc found credentials")
+	}
+}
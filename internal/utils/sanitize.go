@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFileNameBytes keeps names under the 255 byte limit of common file
+// systems, leaving room for PartSuffix and a "(n)" from FindUniqueFilePath.
+const maxFileNameBytes = 255 - len(PartSuffix) - 10
+
+// reservedNames can't be used as file names on Windows, whatever the
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName makes a name from an untrusted source safe to create in
+// the download directory. Directory components are dropped, control and
+// bidi characters removed, characters invalid on Windows or macOS replaced,
+// and the name shortened to fit file system limits. Leading dots are removed
+// so a download can't become a hidden file such as .bashrc. The result is
+// empty if nothing usable is left.
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.Is(unicode.Cc, r), isBidiControl(r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+
+	name = strings.TrimLeft(name, ". ")
+	// Windows drops trailing dots and spaces itself
+	name = strings.TrimRight(name, ". ")
+
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = "_" + name
+	}
+	return truncateFileName(name)
+}
+
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069') ||
+		r == '\u200e' || r == '\u200f'
+}
+
+// truncateFileName shortens name to maxFileNameBytes, keeping a short
+// extension and never splitting a character.
+func truncateFileName(name string) string {
+	if len(name) <= maxFileNameBytes {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	limit := maxFileNameBytes - len(ext)
+	for limit > 0 && !utf8.RuneStart(base[limit]) {
+		limit--
+	}
+	return strings.TrimRight(base[:limit], ". ") + ext
+}
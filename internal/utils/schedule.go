@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BandwidthRule limits bandwidth to Rate KB/s during Interval, 0 meaning
+// unlimited.
+type BandwidthRule struct {
+	Interval *TimeInterval
+	Rate     uint64
+}
+
+// BandwidthSchedule changes a limiter's rate by time of day. The first rule
+// containing the current time applies; outside all of them the limiter's own
+// rate does.
+type BandwidthSchedule struct {
+	Rules []BandwidthRule
+}
+
+// ParseBandwidthSchedule reads rules written as "HH:MM-HH:MM <KB/s>",
+// separated by commas or semicolons, e.g. "09:00-18:00 200, 18:00-20:00
+// unlimited". An empty string yields no schedule.
+func ParseBandwidthSchedule(s string) (*BandwidthSchedule, error) {
+	schedule := &BandwidthSchedule{}
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		entry = strings.NewReplacer("–", "-", " - ", "-").Replace(entry)
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid schedule entry %q (expected 09:00-18:00 200)", strings.TrimSpace(entry))
+		}
+
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range %q", fields[0])
+		}
+		interval, err := NewTimeInterval(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time range %q: %w", fields[0], err)
+		}
+
+		var rate uint64
+		if value := strings.TrimSuffix(strings.ToLower(fields[1]), "kb/s"); value != "unlimited" {
+			if rate, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid rate %q (expected KB/s or unlimited)", fields[1])
+			}
+		}
+		schedule.Rules = append(schedule.Rules, BandwidthRule{interval, rate})
+	}
+	if len(schedule.Rules) == 0 {
+		if strings.TrimSpace(s) != "" {
+			return nil, errors.New("empty bandwidth schedule")
+		}
+		return nil, nil
+	}
+	return schedule, nil
+}
+
+// RateAt returns the rate in KB/s of the rule applying at now.
+func (s *BandwidthSchedule) RateAt(now time.Time) (rate uint64, ok bool) {
+	if s == nil {
+		return 0, false
+	}
+	for _, rule := range s.Rules {
+		if rule.Interval.Contains(now) {
+			return rule.Rate, true
+		}
+	}
+	return 0, false
+}
+
+func (s *BandwidthSchedule) String() string {
+	if s == nil {
+		return ""
+	}
+	entries := make([]string, len(s.Rules))
+	for i, rule := range s.Rules {
+		rate := "unlimited"
+		if rule.Rate > 0 {
+			rate = strconv.FormatUint(rule.Rate, 10)
+		}
+		entries[i] = rule.Interval.String() + " " + rate
+	}
+	return strings.Join(entries, ", ")
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"errors"
 	"time"
 )
 
@@ -10,53 +9,45 @@ type TimeInterval struct {
 	endTime   time.Time
 }
 
+// NewTimeInterval takes times of day as HH:MM:SS or HH:MM. An end before the
+// start is on the next day, so 22:00-06:00 spans midnight, and an end equal
+// to the start makes the interval last all day.
 func NewTimeInterval(start, end string) (*TimeInterval, error) {
-	startTime, err := time.Parse("15:04:05", start)
+	startTime, err := parseClock(start)
 	if err != nil {
 		return nil, err
 	}
-	endTime, err := time.Parse("15:04:05", end)
+	endTime, err := parseClock(end)
 	if err != nil {
 		return nil, err
 	}
 
-	if startTime.After(endTime) {
-		return nil, errors.New("start time must be after end time")
-	}
-
 	return &TimeInterval{
 		startTime: startTime,
 		endTime: endTime,
 	}, nil
 }
 
-func (t *TimeInterval) StartTime() time.Time {
-	now := time.Now()
-	return time.Date(now.Year(), now.Month(), now.Day(), t.startTime.Hour(), t.startTime.Minute(), t.startTime.Second(), 0, now.Location())
-}
-
-func (t *TimeInterval) EndTime() time.Time {
-	now := time.Now()
-	start := t.StartTime()
-	end := time.Date(now.Year(), now.Month(), now.Day(), t.endTime.Hour(), t.endTime.Minute(), t.endTime.Second(), 0, now.Location())
-	if start.After(end) {
-		end = end.Add(24 * time.Hour)
+func parseClock(s string) (time.Time, error) {
+	if c, err := time.Parse("15:04", s); err == nil {
+		return c, nil
 	}
-	return end
+	return time.Parse("15:04:05", s)
 }
 
-func (t *TimeInterval) WaitUntil() {
-	start, end := t.StartTime(), t.EndTime()
-	if time.Now().Before(start) {
-		time.Sleep(start.Sub(time.Now()))
-	} else if time.Now().After(end) {
-		time.Sleep(24 * time.Hour - time.Now().Sub(start))
+// Contains reports whether the time of day of now falls in the interval.
+// The end is excluded.
+func (t *TimeInterval) Contains(now time.Time) bool {
+	clock := func(c time.Time) int { return c.Hour()*3600 + c.Minute()*60 + c.Second() }
+	n, start, end := clock(now), clock(t.startTime), clock(t.endTime)
+	if start == end {
+		return true
+	} else if start < end {
+		return n >= start && n < end
 	}
+	return n >= start || n < end
 }
 
-func CreateLimiter(speedLimit uint64) <-chan time.Time {
-	if speedLimit == 0 {
-		return nil
-	}
-	return time.Tick(time.Second / time.Duration(speedLimit))
-}
\ No newline at end of file
+func (t *TimeInterval) String() string {
+	return t.startTime.Format("15:04:05") + "-" + t.endTime.Format("15:04:05")
+}
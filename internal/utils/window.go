@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Weekdays is a set of days of the week, one bit per time.Weekday.
+type Weekdays uint8
+
+const AllDays Weekdays = 1<<7 - 1
+
+func (d Weekdays) Has(day time.Weekday) bool {
+	return d&(1<<day) != 0
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseWeekdays reads "Mon", a range such as "Mon-Fri" or "Fri-Mon", or one
+// of "daily", "weekdays" and "weekends".
+func parseWeekdays(s string) (Weekdays, bool) {
+	switch strings.ToLower(s) {
+	case "daily":
+		return AllDays, true
+	case "weekdays":
+		return AllDays &^ (1<<time.Saturday | 1<<time.Sunday), true
+	case "weekends":
+		return 1<<time.Saturday | 1<<time.Sunday, true
+	}
+	first, last, isRange := strings.Cut(strings.ToLower(s), "-")
+	from, ok := weekdayNames[first]
+	if !ok {
+		return 0, false
+	}
+	to := from
+	if isRange {
+		if to, ok = weekdayNames[last]; !ok {
+			return 0, false
+		}
+	}
+	var days Weekdays
+	for d := from; ; d = (d + 1) % 7 {
+		days |= 1 << d
+		if d == to {
+			return days, true
+		}
+	}
+}
+
+func (d Weekdays) String() string {
+	if d == AllDays {
+		return "daily"
+	}
+	// Runs of consecutive days, Monday first
+	order := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+	var parts []string
+	for i := 0; i < len(order); i++ {
+		if !d.Has(order[i]) {
+			continue
+		}
+		j := i
+		for j+1 < len(order) && d.Has(order[j+1]) {
+			j++
+		}
+		part := order[i].String()[:3]
+		if j > i {
+			part += "-" + order[j].String()[:3]
+		}
+		parts = append(parts, part)
+		i = j
+	}
+	return strings.Join(parts, " ")
+}
+
+// Window is a daily interval on some days of the week. A window that crosses
+// midnight belongs to the day it starts on.
+type Window struct {
+	Days     Weekdays
+	Interval *TimeInterval // nil for all day
+}
+
+// Schedule is a set of windows in a time zone.
+type Schedule struct {
+	Windows  []Window
+	Location *time.Location // nil for local time
+}
+
+// ParseSchedule reads windows separated by commas, each optional days
+// followed by "HH:MM-HH:MM" or "all day", and an optional time zone entry,
+// e.g. "Mon-Fri 22:00-06:00, Sat-Sun all day, TZ=Europe/Berlin". Windows
+// without days apply daily.
+func ParseSchedule(s string) (*Schedule, error) {
+	schedule := &Schedule{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.NewReplacer("–", "-", " - ", "-").Replace(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if zone, ok := strings.CutPrefix(entry, "TZ="); ok {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				return nil, fmt.Errorf("unknown time zone %q", zone)
+			}
+			schedule.Location = loc
+			continue
+		}
+
+		fields := strings.Fields(entry)
+		window := Window{}
+		for len(fields) > 0 {
+			days, ok := parseWeekdays(fields[0])
+			if !ok {
+				break
+			}
+			window.Days |= days
+			fields = fields[1:]
+		}
+		if window.Days == 0 {
+			window.Days = AllDays
+		}
+
+		switch {
+		case len(fields) == 0 && window.Days != AllDays:
+			// Days alone, like "Sat-Sun"
+		case len(fields) == 2 && strings.EqualFold(fields[0], "all") && strings.EqualFold(fields[1], "day"):
+		case len(fields) == 1:
+			start, end, ok := strings.Cut(fields[0], "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid time range %q (expected 08:00:00-17:00:00)", fields[0])
+			}
+			interval, err := NewTimeInterval(start, end)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time range %q: %w", fields[0], err)
+			}
+			window.Interval = interval
+		default:
+			return nil, fmt.Errorf("invalid window %q (expected Mon-Fri 08:00-17:00 or Sat-Sun all day)", entry)
+		}
+		schedule.Windows = append(schedule.Windows, window)
+	}
+	if len(schedule.Windows) == 0 {
+		return nil, errors.New("no time window given")
+	}
+	return schedule, nil
+}
+
+// occurrence returns the window's span starting on the day of date, if the
+// window is on that day.
+func (w Window) occurrence(date time.Time) (start, end time.Time, ok bool) {
+	if !w.Days.Has(date.Weekday()) {
+		return start, end, false
+	}
+	y, m, d := date.Date()
+	loc := date.Location()
+	if w.Interval == nil {
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), time.Date(y, m, d+1, 0, 0, 0, 0, loc), true
+	}
+	s, e := w.Interval.startTime, w.Interval.endTime
+	start = time.Date(y, m, d, s.Hour(), s.Minute(), s.Second(), 0, loc)
+	end = time.Date(y, m, d, e.Hour(), e.Minute(), e.Second(), 0, loc)
+	if !end.After(start) {
+		end = time.Date(y, m, d+1, e.Hour(), e.Minute(), e.Second(), 0, loc)
+	}
+	return start, end, true
+}
+
+// spans lists the windows' spans from the day before now to a week after.
+func (s *Schedule) spans(now time.Time) [][2]time.Time {
+	if s.Location != nil {
+		now = now.In(s.Location)
+	}
+	var spans [][2]time.Time
+	y, m, d := now.Date()
+	for offset := -1; offset <= 7; offset++ {
+		date := time.Date(y, m, d+offset, 12, 0, 0, 0, now.Location())
+		for _, w := range s.Windows {
+			if start, end, ok := w.occurrence(date); ok {
+				spans = append(spans, [2]time.Time{start, end})
+			}
+		}
+	}
+	return spans
+}
+
+// Active reports whether now is inside a window, and if so when the windows
+// covering it, back to back or overlapping, end.
+func (s *Schedule) Active(now time.Time) (until time.Time, open bool) {
+	spans := s.spans(now)
+	until = now
+	for extended := true; extended; {
+		extended = false
+		for _, span := range spans {
+			if !span[0].After(until) && span[1].After(until) {
+				until, open, extended = span[1], true, true
+			}
+		}
+	}
+	return until, open
+}
+
+// Next returns the start of the first window after now, or the zero time if
+// there is none within a week.
+func (s *Schedule) Next(now time.Time) time.Time {
+	var next time.Time
+	for _, span := range s.spans(now) {
+		if span[0].After(now) && (next.IsZero() || span[0].Before(next)) {
+			next = span[0]
+		}
+	}
+	return next
+}
+
+func (s *Schedule) String() string {
+	if s == nil {
+		return ""
+	}
+	var entries []string
+	for _, w := range s.Windows {
+		var entry string
+		if w.Days != AllDays {
+			entry = w.Days.String() + " "
+		}
+		if w.Interval == nil {
+			entry += "all day"
+		} else {
+			entry += w.Interval.String()
+		}
+		entries = append(entries, strings.TrimSpace(entry))
+	}
+	if s.Location != nil {
+		entries = append(entries, "TZ="+s.Location.String())
+	}
+	return strings.Join(entries, ", ")
+}
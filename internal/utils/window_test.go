@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a time in the week of Monday 12 October 2026, UTC.
+func at(day time.Weekday, hour, min int) time.Time {
+	return time.Date(2026, 10, 11+int(day), hour, min, 0, 0, time.UTC)
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // empty if the input is invalid
+	}{
+		{"Mon-Fri 22:00-06:00", "Mon-Fri 22:00:00-06:00:00"},
+		{"Mon–Fri 22:00–06:00, Sat–Sun all day", "Mon-Fri 22:00:00-06:00:00, Sat-Sun all day"},
+		{"Fri-Mon 08:00 - 17:00", "Mon Fri-Sun 08:00:00-17:00:00"},
+		{"weekends", "Sat-Sun all day"},
+		{"Mon Wed 10:00-12:00", "Mon Wed 10:00:00-12:00:00"},
+		{"daily 22:00-06:00", "22:00:00-06:00:00"},
+		{"09:00-17:00, TZ=Asia/Tokyo", "09:00:00-17:00:00, TZ=Asia/Tokyo"},
+		{"all day", "all day"},
+		{"daily", ""},
+		{"", ""},
+		{"TZ=UTC", ""},
+		{"Mon-Fri 25:00-06:00", ""},
+		{"Mon-Fri 22:00", ""},
+		{"Funday 10:00-12:00", ""},
+		{"Mon-Fri 22:00-06:00 extra", ""},
+		{"09:00-17:00, TZ=Nowhere/Else", ""},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.input)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseSchedule(%q) = %q, want an error", tt.input, schedule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.input, err)
+			continue
+		}
+		if got := schedule.String(); got != tt.want {
+			t.Errorf("ParseSchedule(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		// The description must parse back to the same schedule
+		if again, err := ParseSchedule(schedule.String()); err != nil || again.String() != tt.want {
+			t.Errorf("ParseSchedule(%q) = %q, %v, want %q", schedule, again, err, tt.want)
+		}
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		schedule string
+		now      time.Time
+		open     bool
+		until    time.Time // when open
+		next     time.Time // when closed
+	}{
+		// A window crossing midnight belongs to the day it starts on
+		{"Mon-Fri 22:00-06:00", at(time.Monday, 3, 0), false, time.Time{}, at(time.Monday, 22, 0)},
+		{"Mon-Fri 22:00-06:00", at(time.Monday, 23, 0), true, at(time.Tuesday, 6, 0), time.Time{}},
+		{"Mon-Fri 22:00-06:00", at(time.Saturday, 3, 0), true, at(time.Saturday, 6, 0), time.Time{}},
+		{"Mon-Fri 22:00-06:00", at(time.Saturday, 23, 0), false, time.Time{}, at(time.Monday, 22, 0).AddDate(0, 0, 7)},
+		{"Mon-Fri 22:00-06:00", at(time.Tuesday, 6, 0), false, time.Time{}, at(time.Tuesday, 22, 0)},
+		{"Mon-Fri 22:00-06:00", at(time.Tuesday, 22, 0), true, at(time.Wednesday, 6, 0), time.Time{}},
+
+		// Back to back and overlapping spans run together
+		{"Mon 22:00-00:00, Tue 00:00-06:00", at(time.Monday, 23, 0), true, at(time.Tuesday, 6, 0), time.Time{}},
+		{"Mon 22:00-00:00, Tue all day", at(time.Monday, 23, 0), true, at(time.Wednesday, 0, 0), time.Time{}},
+		{"Mon-Fri 22:00-06:00, Sat-Sun all day", at(time.Friday, 23, 0), true, at(time.Monday, 0, 0).AddDate(0, 0, 7), time.Time{}},
+		{"Mon 09:00-12:00, Mon 11:00-13:00", at(time.Monday, 10, 0), true, at(time.Monday, 13, 0), time.Time{}},
+		{"Mon 09:00-12:00, Mon 13:00-14:00", at(time.Monday, 10, 0), true, at(time.Monday, 12, 0), time.Time{}},
+		{"Mon 09:00-12:00, Mon 13:00-14:00", at(time.Monday, 12, 30), false, time.Time{}, at(time.Monday, 13, 0)},
+
+		// Days alone and an end equal to the start last all day
+		{"Sat-Sun", at(time.Saturday, 10, 0), true, at(time.Monday, 0, 0).AddDate(0, 0, 7), time.Time{}},
+		{"Wed 08:00-08:00", at(time.Wednesday, 7, 0), false, time.Time{}, at(time.Wednesday, 8, 0)},
+		{"Wed 08:00-08:00", at(time.Thursday, 7, 59), true, at(time.Thursday, 8, 0), time.Time{}},
+
+		// 09:00-17:00 in Tokyo is 00:00-08:00 UTC
+		{"09:00-17:00, TZ=Asia/Tokyo", at(time.Monday, 1, 0), true, at(time.Monday, 8, 0), time.Time{}},
+		{"09:00-17:00, TZ=Asia/Tokyo", at(time.Monday, 9, 0), false, time.Time{}, at(time.Tuesday, 0, 0)},
+		{"Mon 09:00-17:00, TZ=Asia/Tokyo", at(time.Sunday, 23, 0), false, time.Time{}, at(time.Monday, 0, 0)},
+		{"Mon 09:00-17:00, TZ=Asia/Tokyo", at(time.Monday, 1, 0).In(tokyo), true, at(time.Monday, 8, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", tt.schedule, err)
+		}
+		until, open := schedule.Active(tt.now)
+		if open != tt.open {
+			t.Errorf("%q at %v: open = %v, want %v", tt.schedule, tt.now, open, tt.open)
+			continue
+		}
+		if open && !until.Equal(tt.until) {
+			t.Errorf("%q at %v: open until %v, want %v", tt.schedule, tt.now, until, tt.until)
+		}
+		if !open {
+			if next := schedule.Next(tt.now); !next.Equal(tt.next) {
+				t.Errorf("%q at %v: next window at %v, want %v", tt.schedule, tt.now, next, tt.next)
+			}
+		}
+	}
+}